@@ -0,0 +1,229 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StoredToken is a token of a user that is kept in your token store.
+type StoredToken struct {
+	Token    Secret        // The token.
+	TypeHint TokenTypeHint // The type of the token, leave it empty if unknown.
+}
+
+// DeleteAccountRequest describes the tokens of a user whose account is
+// deleted.
+type DeleteAccountRequest struct {
+	// Subject is the user identifier (sub) of the user, it is used in the
+	// report only.
+	Subject string
+
+	// ClientID is the identifier (App ID or Services ID) the tokens were
+	// issued to.
+	ClientID string
+
+	// ClientSecret is the client secret of ClientID. Use GenerateClientSecret
+	// function to create it.
+	ClientSecret Secret
+
+	// Tokens are all the tokens of the user kept in your token store.
+	Tokens []StoredToken
+
+	// Retry is how a token failing temporarily is revoked again before
+	// DeleteAccount gives up on it, see DeleteAccountReport.Failed. Leave
+	// MaxAttempts zero for DefaultRetryPolicy, or set it to 1 when the client
+	// retries already.
+	Retry RetryPolicy
+
+	// Hooks are called along the way, to clean up your token store.
+	Hooks DeleteAccountHooks
+
+	// Audit records the account deletion once it is done. The revocations
+	// are recorded by the audit sink of the client, see WithAuditSink, so
+	// this is usually the same sink. Optional.
+	Audit AuditSink
+}
+
+// DeleteAccountHooks are called by DeleteAccount. A hook returning an error
+// is recorded in the report, but does not stop the deletion.
+type DeleteAccountHooks struct {
+	// OnTokenRevoked is called when a token is revoked or found already
+	// invalid, so that it can be removed from your token store.
+	OnTokenRevoked func(ctx context.Context, subject string, token StoredToken) error
+
+	// OnCompleted is called when every token is revoked or found already
+	// invalid, so that the user can be removed from your token store.
+	OnCompleted func(ctx context.Context, report *DeleteAccountReport) error
+}
+
+// TokenRevocation is the result of the revocation of a single token.
+type TokenRevocation struct {
+	Fingerprint string            `json:"fingerprint"`          // The fingerprint of the token, see TokenFingerprint.
+	TypeHint    TokenTypeHint     `json:"type_hint"`            // The type of the token.
+	Outcome     RevocationOutcome `json:"outcome"`              // The outcome of the revocation.
+	Attempts    int               `json:"attempts"`             // The number of revocation requests sent.
+	ErrorCode   string            `json:"error_code,omitempty"` // Apple's error code, or the client-side failure. See RequestErrorCode.
+	Err         error             `json:"-"`                    // The error of the last attempt, if the revocation failed.
+	HookErr     error             `json:"-"`                    // The error of DeleteAccountHooks.OnTokenRevoked, if any.
+}
+
+// DeleteAccountReport is the outcome of DeleteAccount.
+type DeleteAccountReport struct {
+	Subject    string            `json:"subject"`
+	ClientID   string            `json:"client_id"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Tokens     []TokenRevocation `json:"tokens"`
+	HookErr    error             `json:"-"` // The error of DeleteAccountHooks.OnCompleted, if any.
+}
+
+// Completed reports whether every token is revoked or already invalid.
+func (r *DeleteAccountReport) Completed() bool {
+	for _, t := range r.Tokens {
+		if t.Outcome == RevocationFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the revocations which failed.
+func (r *DeleteAccountReport) Failed() []TokenRevocation {
+	failed := make([]TokenRevocation, 0)
+	for _, t := range r.Tokens {
+		if t.Outcome == RevocationFailed {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// DeleteAccount revokes every stored token of a user whose account is
+// deleted, as required by the App Store Review Guidelines.
+//
+// Temporary failures are retried, and a token that is already expired or
+// revoked counts as a success. DeleteAccount always returns a report, and an
+// error if any token could not be revoked, in which case it is safe to call
+// DeleteAccount again with the tokens that failed.
+//
+// Ref: https://developer.apple.com/documentation/sign_in_with_apple/revoke_tokens
+func DeleteAccount(ctx context.Context, client Client, req DeleteAccountRequest) (*DeleteAccountReport, error) {
+	policy := jobRetryPolicy(req.Retry)
+
+	ctx = ContextWithAuditSubject(ctx, req.Subject)
+
+	report := &DeleteAccountReport{
+		Subject:   req.Subject,
+		ClientID:  req.ClientID,
+		StartedAt: time.Now(),
+		Tokens:    make([]TokenRevocation, 0, len(req.Tokens)),
+	}
+
+	for _, token := range req.Tokens {
+		result := revokeStoredToken(ctx, client, req.ClientID, req.ClientSecret, token, policy, nil)
+		if result.Outcome != RevocationFailed && req.Hooks.OnTokenRevoked != nil {
+			result.HookErr = req.Hooks.OnTokenRevoked(ctx, req.Subject, token)
+		}
+		report.Tokens = append(report.Tokens, result)
+	}
+
+	report.FinishedAt = time.Now()
+
+	var err error
+	if !report.Completed() {
+		err = fmt.Errorf("failed to revoke %d of %d tokens of %q", len(report.Failed()), len(report.Tokens), req.Subject)
+	}
+	if req.Audit != nil {
+		record := AuditRecord{
+			Time:     report.FinishedAt.UTC(),
+			Action:   AuditDeleteAccount,
+			Subject:  req.Subject,
+			ClientID: req.ClientID,
+			Outcome:  "success",
+		}
+		if err != nil {
+			record.Outcome = "failed"
+		}
+		if auditErr := req.Audit.Record(ctx, record); auditErr != nil && err == nil {
+			err = fmt.Errorf("failed to record audit: %w", auditErr)
+		}
+	}
+	if err != nil {
+		return report, err
+	}
+	if req.Hooks.OnCompleted != nil {
+		report.HookErr = req.Hooks.OnCompleted(ctx, report)
+	}
+	return report, nil
+}
+
+func revokeStoredToken(ctx context.Context, client Client, clientID string, clientSecret Secret, token StoredToken, policy RetryPolicy, limit *limiter) TokenRevocation {
+	result := TokenRevocation{
+		Fingerprint: TokenFingerprint(token.Token),
+		TypeHint:    token.TypeHint,
+	}
+
+	// the other hints are tried here rather than with TryOtherHint, so that
+	// every request sent takes a token of the limiter
+	hints, err := revokeHints(token.TypeHint, true)
+	if err != nil {
+		result.Outcome, result.Err, result.ErrorCode = RevocationFailed, err, revocationErrorCode(err)
+		return result
+	}
+
+	err = retryJob(ctx, policy, func() (err error) {
+		for _, hint := range hints {
+			if err = limit.wait(ctx, EndpointRevoke, clientID); err != nil {
+				result.Outcome = RevocationFailed
+				return err
+			}
+			result.Attempts++
+
+			var rsp *RevokeResponse
+			rsp, err = client.RevokeToken(ctx, RevokeRequest{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				Token:        token.Token,
+				TypeHint:     hint,
+			})
+			if rsp == nil {
+				// a Client other than the one of NewClient may answer without
+				// a response
+				rsp = revokeErrorResponse(err)
+				rsp.Outcome = revocationOutcome(err)
+			}
+			result.Outcome = rsp.Outcome
+
+			// RevokeToken reports an invalid_grant as an already invalid
+			// token, but it may only mean that the token is of another type
+			if !isHintRejected(rsp.Error) {
+				break
+			}
+		}
+		if result.Outcome != RevocationFailed {
+			return nil
+		}
+		return err
+	})
+
+	result.Err = err
+	if err != nil {
+		result.ErrorCode = revocationErrorCode(err)
+	}
+	return result
+}
+
+// revocationErrorCode returns Apple's error code of err, or the code of the
+// client-side failure
+func revocationErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Code != "" {
+			return e.Code
+		}
+		return RequestErrorCode(&Response{StatusCode: e.StatusCode}, nil)
+	}
+	return RequestErrorCode(nil, err)
+}
@@ -0,0 +1,78 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestDeleteAccountTriesTheOtherHint(t *testing.T) {
+	var hints []string
+	c := newStubClient(t, func(req *Request) *Response {
+		hints = append(hints, req.Form["token_type_hint"])
+		if req.Form["token_type_hint"] == string(TokenTypeRefreshToken) {
+			return &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"invalid_grant"}`)}
+		}
+		return &Response{StatusCode: http.StatusOK}
+	})
+
+	// an access token stored without its type is rejected as a refresh token
+	report, err := DeleteAccount(context.Background(), c, DeleteAccountRequest{
+		Subject:      "001234.abcd",
+		ClientID:     "com.example.app",
+		ClientSecret: "secret",
+		Tokens:       []StoredToken{{Token: "a1"}},
+	})
+	if err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if got := report.Tokens[0]; got.Outcome != RevocationRevoked || got.Attempts != 2 {
+		t.Errorf("revocation = %+v, want revoked after 2 attempts", got)
+	}
+	if len(hints) != 2 || hints[0] != string(TokenTypeRefreshToken) || hints[1] != string(TokenTypeAccessToken) {
+		t.Errorf("hints = %v, want [refresh_token access_token]", hints)
+	}
+}
+
+func TestDeleteAccountAlreadyInvalidWithBothHints(t *testing.T) {
+	c := newStubClient(t, func(req *Request) *Response {
+		return &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"invalid_grant"}`)}
+	})
+
+	report, err := DeleteAccount(context.Background(), c, DeleteAccountRequest{
+		Subject:      "001234.abcd",
+		ClientID:     "com.example.app",
+		ClientSecret: "secret",
+		Tokens:       []StoredToken{{Token: "r1", TypeHint: TokenTypeRefreshToken}},
+	})
+	if err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if got := report.Tokens[0]; got.Outcome != RevocationAlreadyInvalid || got.Attempts != 2 {
+		t.Errorf("revocation = %+v, want already invalid after 2 attempts", got)
+	}
+}
+
+// nilRevokeClient is a Client answering every revocation without a response
+type nilRevokeClient struct {
+	Client
+}
+
+func (nilRevokeClient) RevokeToken(context.Context, RevokeRequest) (*RevokeResponse, error) {
+	return nil, nil
+}
+
+func TestDeleteAccountWithoutRevokeResponse(t *testing.T) {
+	report, err := DeleteAccount(context.Background(), nilRevokeClient{}, DeleteAccountRequest{
+		Subject:      "001234.abcd",
+		ClientID:     "com.example.app",
+		ClientSecret: "secret",
+		Tokens:       []StoredToken{{Token: "r1", TypeHint: TokenTypeRefreshToken}},
+	})
+	if err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if got := report.Tokens[0]; got.Outcome != RevocationRevoked {
+		t.Errorf("revocation = %+v, want revoked", got)
+	}
+}
@@ -0,0 +1,298 @@
+package apple
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditAction is the kind of an audited request.
+type AuditAction string
+
+const (
+	AuditRevokeToken         AuditAction = "revoke_token"          // A token revocation.
+	AuditDeleteAccount       AuditAction = "delete_account"        // An account deletion, see DeleteAccount.
+	AuditMigrationToken      AuditAction = "migration_token"       // A migration access token request.
+	AuditGenerateTransferSub AuditAction = "generate_transfer_sub" // A transfer identifier generation.
+	AuditExchangeIdentifier  AuditAction = "exchange_identifier"   // A transfer identifier exchange.
+)
+
+// AuditRecord is the record of a revocation, an account deletion or a user
+// migration request sent to Apple. It never contains a token, only its
+// fingerprint.
+type AuditRecord struct {
+	Time             time.Time     `json:"time"`
+	Action           AuditAction   `json:"action"`
+	Subject          string        `json:"subject,omitempty"`           // The user identifier, see ContextWithAuditSubject.
+	ClientID         string        `json:"client_id"`                   // The client ID of the request.
+	Target           string        `json:"target,omitempty"`            // The recipient team of a transfer, or the new sub of an exchange.
+	TokenType        TokenTypeHint `json:"token_type,omitempty"`        // The type of the token revoked.
+	TokenFingerprint string        `json:"token_fingerprint,omitempty"` // The fingerprint of the token, see TokenFingerprint.
+	Outcome          string        `json:"outcome"`                     // "success", "failed", or a RevocationOutcome for revocations.
+	StatusCode       int           `json:"status_code,omitempty"`       // The HTTP status code of Apple's response.
+	ErrorCode        string        `json:"error_code,omitempty"`        // Apple's error code, or the client-side failure.
+}
+
+// AuditSink receives an AuditRecord for every revocation, account deletion
+// and user migration request sent to Apple. See WithAuditSink.
+type AuditSink interface {
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+// AuditSinkFunc is an adapter to use an ordinary function as an AuditSink.
+type AuditSinkFunc func(ctx context.Context, record AuditRecord) error
+
+// Record calls f(ctx, record).
+func (f AuditSinkFunc) Record(ctx context.Context, record AuditRecord) error {
+	return f(ctx, record)
+}
+
+type auditSubjectKey struct{}
+
+// ContextWithAuditSubject returns a copy of ctx carrying the user identifier
+// (sub) to record in the audit records of the requests made with it.
+func ContextWithAuditSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, auditSubjectKey{}, subject)
+}
+
+// AuditSubjectFromContext returns the user identifier carried by ctx, if any.
+func AuditSubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(auditSubjectKey{}).(string)
+	return subject
+}
+
+// audit sends a record to the audit sink of the client, if there is one
+func (c *client) audit(ctx context.Context, record AuditRecord, err error) {
+	if c.auditSink == nil {
+		return
+	}
+
+	record.Time = time.Now().UTC()
+	if record.Subject == "" {
+		record.Subject = AuditSubjectFromContext(ctx)
+	}
+	if record.Outcome == "" {
+		record.Outcome = "success"
+		if err != nil {
+			record.Outcome = "failed"
+		}
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		record.StatusCode = e.StatusCode
+	}
+	if err != nil {
+		record.ErrorCode = revocationErrorCode(err)
+	}
+
+	if err = c.auditSink.Record(ctx, record); err != nil {
+		c.logger.Error("failed to record audit", "action", record.Action, "error", err)
+	}
+}
+
+// auditEntry is a line of a FileAuditSink
+type auditEntry struct {
+	Seq      int64           `json:"seq"`
+	PrevHash string          `json:"prev_hash"`
+	Record   json.RawMessage `json:"record"`
+	Hash     string          `json:"hash"`
+}
+
+func (e *auditEntry) digest() string {
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatInt(e.Seq, 10)))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(e.PrevHash))
+	h.Write([]byte{'\n'})
+	h.Write(e.Record)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FileAuditSink is an AuditSink appending the records to a JSONL file. Every
+// line carries the hash of the previous line, so that a line modified,
+// removed or inserted afterward is detected by VerifyAuditLog.
+//
+// The chain alone cannot tell that the last lines were removed. Store the
+// Head of the sink outside of the file, such as in a database, and check it
+// with VerifyAuditLogHead to detect it.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+	seq  int64
+	hash string
+}
+
+// maxAuditLineSize is the maximum size of a line of an audit log
+const maxAuditLineSize = 1024 * 1024
+
+// OpenFileAuditSink opens the audit log at path, creating it if needed. An
+// existing log is verified before new records are appended to it, after the
+// last line is truncated if a crash tore it while it was written.
+func OpenFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = repairAuditLogTail(file); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot repair audit log %q: %w", path, err)
+	}
+
+	sink := &FileAuditSink{file: file}
+	if sink.seq, sink.hash, err = verifyAuditLog(file); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot append to audit log %q: %w", path, err)
+	}
+	return sink, nil
+}
+
+// repairAuditLogTail truncates the last line of the log if it is torn, and
+// terminates it if it is only missing its newline, then rewinds the file
+func repairAuditLogTail(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	start := max(size-maxAuditLineSize, 0)
+	tail := make([]byte, size-start)
+	if _, err = file.ReadAt(tail, start); err != nil {
+		return err
+	}
+
+	i := bytes.LastIndexByte(tail, '\n')
+	last := tail[i+1:]
+	switch {
+	case len(bytes.TrimSpace(last)) == 0:
+	case i < 0 && start > 0:
+		// the line is too long, verifyAuditLog reports it
+	case json.Valid(last):
+		_, err = file.Write([]byte{'\n'})
+	default:
+		err = file.Truncate(size - int64(len(last)))
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+func (s *FileAuditSink) Record(_ context.Context, record AuditRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &auditEntry{Seq: s.seq + 1, PrevHash: s.hash, Record: raw}
+	entry.Hash = entry.digest()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.seq, s.hash = entry.Seq, entry.Hash
+	return nil
+}
+
+// AuditLogHead is the last entry of an audit log written by a FileAuditSink.
+type AuditLogHead struct {
+	Records int64  `json:"records"` // The number of records in the log.
+	Hash    string `json:"hash"`    // The hash of the last record.
+}
+
+// Head returns the head of the audit log, as of the last record written.
+func (s *FileAuditSink) Head() AuditLogHead {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return AuditLogHead{Records: s.seq, Hash: s.hash}
+}
+
+// Close closes the audit log.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// VerifyAuditLog verifies the hash chain of an audit log written by a
+// FileAuditSink, and returns the number of records in it. The error tells
+// the first line that was tampered with.
+func VerifyAuditLog(r io.Reader) (records int64, err error) {
+	records, _, err = verifyAuditLog(r)
+	return records, err
+}
+
+// VerifyAuditLogHead verifies the audit log like VerifyAuditLog, and that it
+// still holds the record of head, a Head of its FileAuditSink stored
+// elsewhere, so that the removal of the last records is detected too.
+func VerifyAuditLogHead(r io.Reader, head AuditLogHead) (records int64, err error) {
+	found := head.Records == 0
+	records, _, err = verifyAuditLogFunc(r, func(seq int64, hash string) {
+		if seq == head.Records {
+			found = hash == head.Hash
+		}
+	})
+	switch {
+	case err != nil:
+		return records, err
+	case records < head.Records:
+		return records, fmt.Errorf("log ends at record %d before the head at record %d, records were removed", records, head.Records)
+	case !found:
+		return records, fmt.Errorf("record %d does not match the head, the log was rewritten", head.Records)
+	}
+	return records, nil
+}
+
+func verifyAuditLog(r io.Reader) (seq int64, hash string, err error) {
+	return verifyAuditLogFunc(r, nil)
+}
+
+// verifyAuditLogFunc verifies the hash chain of an audit log, calling fn with
+// every entry verified, and returns the last one
+func verifyAuditLogFunc(r io.Reader, fn func(seq int64, hash string)) (seq int64, hash string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxAuditLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := &auditEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return seq, hash, fmt.Errorf("line %d: invalid entry: %w", line, err)
+		}
+		if entry.Seq != seq+1 || entry.PrevHash != hash {
+			return seq, hash, fmt.Errorf("line %d: broken chain, a record is missing or out of order", line)
+		}
+		if entry.digest() != entry.Hash {
+			return seq, hash, fmt.Errorf("line %d: hash mismatch, the record was modified", line)
+		}
+		seq, hash = entry.Seq, entry.Hash
+		if fn != nil {
+			fn(seq, hash)
+		}
+	}
+	return seq, hash, scanner.Err()
+}
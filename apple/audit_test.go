@@ -0,0 +1,193 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAuditLog writes n records of the subjects prefix+"a", prefix+"b"...
+// with a FileAuditSink, and returns the lines of the log and the head of the
+// sink
+func writeAuditLog(t *testing.T, prefix string, n int) ([]string, AuditLogHead) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := OpenFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		record := AuditRecord{Action: AuditRevokeToken, ClientID: "com.example.app", Subject: prefix + string(rune('a'+i)), Outcome: "success"}
+		if err = sink.Record(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := sink.Head()
+	_ = sink.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n"), head
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	lines, _ := writeAuditLog(t, "sub", 4)
+
+	tests := []struct {
+		name        string
+		lines       []string
+		wantRecords int64
+		wantErr     string
+	}{
+		{"intact", lines, 4, ""},
+		{"empty", nil, 0, ""},
+		{"modified", []string{lines[0], strings.Replace(lines[1], `"subb"`, `"subx"`, 1), lines[2], lines[3]}, 1, "line 2: hash mismatch"},
+		{"removed", []string{lines[0], lines[2], lines[3]}, 1, "line 2: broken chain"},
+		{"inserted", []string{lines[0], lines[1], lines[1], lines[2], lines[3]}, 2, "line 3: broken chain"},
+		{"reordered", []string{lines[0], lines[2], lines[1], lines[3]}, 1, "line 2: broken chain"},
+		{"invalid", []string{lines[0], "{not json}\n"}, 1, "line 2: invalid entry"},
+		{"truncated tail", lines[:2], 2, ""}, // only detected with the head
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := VerifyAuditLog(strings.NewReader(strings.Join(tt.lines, "")))
+			if records != tt.wantRecords {
+				t.Errorf("records = %d, want %d", records, tt.wantRecords)
+			}
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogHead(t *testing.T) {
+	lines, head := writeAuditLog(t, "sub", 3)
+	other, otherHead := writeAuditLog(t, "other", 3)
+	if head.Records != 3 || head.Hash == "" {
+		t.Fatalf("head = %+v, want 3 records", head)
+	}
+
+	tests := []struct {
+		name    string
+		log     string
+		head    AuditLogHead
+		wantErr string
+	}{
+		{"intact", strings.Join(lines, ""), head, ""},
+		{"appended since the head", strings.Join(lines, ""), AuditLogHead{Records: 2, Hash: hashOfLine(t, lines[1])}, ""},
+		{"truncated tail", strings.Join(lines[:2], ""), head, "records were removed"},
+		{"rewritten", strings.Join(other, ""), head, "does not match the head"},
+		{"zero head", "", AuditLogHead{}, ""},
+		{"other head", strings.Join(other, ""), otherHead, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyAuditLogHead(strings.NewReader(tt.log), tt.head)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// hashOfLine returns the hash of an entry of the log
+func hashOfLine(t *testing.T, line string) string {
+	t.Helper()
+	entry := &auditEntry{}
+	if err := json.Unmarshal([]byte(line), entry); err != nil {
+		t.Fatal(err)
+	}
+	return entry.Hash
+}
+
+func TestOpenFileAuditSinkRefusesTamperedLog(t *testing.T) {
+	lines, _ := writeAuditLog(t, "sub", 3)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(lines[0]+lines[2]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileAuditSink(path); err == nil {
+		t.Error("OpenFileAuditSink() of a tampered log error = nil, want an error")
+	}
+}
+
+func TestOpenFileAuditSinkRepairsTornTail(t *testing.T) {
+	lines, _ := writeAuditLog(t, "sub", 3)
+
+	tests := []struct {
+		name string
+		log  string
+		want int64
+	}{
+		{"torn last line", lines[0] + lines[1] + lines[2][:len(lines[2])/2], 2},
+		{"unterminated last line", lines[0] + lines[1] + strings.TrimSuffix(lines[2], "\n"), 3},
+		{"torn only line", lines[0][:10], 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			if err := os.WriteFile(path, []byte(tt.log), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			sink, err := OpenFileAuditSink(path)
+			if err != nil {
+				t.Fatalf("OpenFileAuditSink() error = %v", err)
+			}
+			if head := sink.Head(); head.Records != tt.want {
+				t.Errorf("head = %+v, want %d records", head, tt.want)
+			}
+			if err = sink.Record(context.Background(), AuditRecord{Action: AuditRevokeToken, Outcome: "success"}); err != nil {
+				t.Fatal(err)
+			}
+			_ = sink.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if records, err := VerifyAuditLog(strings.NewReader(string(data))); err != nil || records != tt.want+1 {
+				t.Errorf("VerifyAuditLog() = %d, %v, want %d records", records, err, tt.want+1)
+			}
+		})
+	}
+}
+
+func TestDeleteAccountRecordsInAuditSink(t *testing.T) {
+	var records []AuditRecord
+	sink := AuditSinkFunc(func(_ context.Context, record AuditRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	c := newStubClient(t, func(*Request) *Response {
+		return &Response{StatusCode: http.StatusOK}
+	}, WithAuditSink(sink))
+
+	_, err := DeleteAccount(context.Background(), c, DeleteAccountRequest{
+		Subject:      "001234.abcd",
+		ClientID:     "com.example.app",
+		ClientSecret: "secret",
+		Tokens:       []StoredToken{{Token: "r1", TypeHint: TokenTypeRefreshToken}},
+		Audit:        sink,
+	})
+	if err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+
+	var actions []AuditAction
+	for _, record := range records {
+		actions = append(actions, record.Action)
+		if record.Subject != "001234.abcd" {
+			t.Errorf("record %s subject = %q, want %q", record.Action, record.Subject, "001234.abcd")
+		}
+	}
+	if len(actions) != 2 || actions[0] != AuditRevokeToken || actions[1] != AuditDeleteAccount {
+		t.Errorf("actions = %v, want [revoke_token delete_account]", actions)
+	}
+}
@@ -0,0 +1,184 @@
+package apple
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	appleIDPattern  = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+$`)
+	keyFilePattern  = regexp.MustCompile(`^AuthKey_([A-Z0-9]{10})\.p8$`)
+)
+
+// Validate checks the AuthKey before any request is sent, so that a typo
+// is reported precisely instead of as an invalid_client error of Apple. It
+// checks that KeyID and TeamID are 10 uppercase letters or digits, that
+// ClientID is a bundle ID or Services ID without the Team ID, and that
+// SigningKey is a PEM-encoded PKCS#8 ECDSA P-256 private key, or Signer
+// holds an ECDSA P-256 key. All the problems are reported at once.
+func (k AuthKey) Validate() error {
+	var errs []error
+
+	if !appleIDPattern.MatchString(k.KeyID) {
+		errs = append(errs, fmt.Errorf("key ID %q must be 10 uppercase letters or digits", k.KeyID))
+	}
+	if !appleIDPattern.MatchString(k.TeamID) {
+		errs = append(errs, fmt.Errorf("team ID %q must be 10 uppercase letters or digits", k.TeamID))
+	}
+	switch {
+	case k.ClientID == "":
+		errs = append(errs, errors.New("client ID is required"))
+	case k.TeamID != "" && strings.HasPrefix(k.ClientID, k.TeamID+"."):
+		errs = append(errs, fmt.Errorf("client ID %q must not include the team ID", k.ClientID))
+	case !clientIDPattern.MatchString(k.ClientID):
+		errs = append(errs, fmt.Errorf("client ID %q must be a reverse-DNS bundle ID or Services ID, such as com.example.app", k.ClientID))
+	}
+
+	switch {
+	case k.Signer != nil:
+		if public, ok := k.Signer.Public().(*ecdsa.PublicKey); !ok || public.Curve != elliptic.P256() {
+			errs = append(errs, errors.New("signer does not hold an ECDSA P-256 key"))
+		}
+	case k.SigningKey == "":
+		errs = append(errs, errors.New("signing key is required"))
+	default:
+		if _, err := parseSigningKey(k.SigningKey); err != nil {
+			errs = append(errs, fmt.Errorf("invalid signing key: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// LoadAuthKeyFromFile loads the AuthKey of the .p8 file downloaded from the
+// Apple Developer Portal. The key ID is taken from the file name, which is
+// AuthKey_<KeyID>.p8.
+func LoadAuthKeyFromFile(path, teamID, clientID string) (AuthKey, error) {
+	match := keyFilePattern.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return AuthKey{}, fmt.Errorf("cannot find the key ID in file name %q, expected AuthKey_<KeyID>.p8", filepath.Base(path))
+	}
+
+	signingKey, err := os.ReadFile(path)
+	if err != nil {
+		return AuthKey{}, err
+	}
+
+	return validAuthKey(AuthKey{
+		KeyID:      match[1],
+		ClientID:   clientID,
+		TeamID:     teamID,
+		SigningKey: Secret(signingKey),
+	})
+}
+
+// LoadAuthKeyFromEnv loads the AuthKey from the environment variables named
+// after prefix, such as APPLE_KEY_ID for the prefix "APPLE":
+//
+//	<prefix>_KEY_ID
+//	<prefix>_TEAM_ID
+//	<prefix>_CLIENT_ID
+//	<prefix>_SIGNING_KEY       the PEM-encoded private key, newlines may be escaped as \n
+//	<prefix>_SIGNING_KEY_FILE  the path of the .p8 file, in place of <prefix>_SIGNING_KEY
+func LoadAuthKeyFromEnv(prefix string) (AuthKey, error) {
+	env := func(name string) string {
+		return os.Getenv(prefix + "_" + name)
+	}
+
+	config := authKeyConfig{
+		KeyID:          env("KEY_ID"),
+		ClientID:       env("CLIENT_ID"),
+		TeamID:         env("TEAM_ID"),
+		SigningKey:     env("SIGNING_KEY"),
+		SigningKeyFile: env("SIGNING_KEY_FILE"),
+	}
+	if !strings.Contains(config.SigningKey, "\n") {
+		config.SigningKey = strings.ReplaceAll(config.SigningKey, `\n`, "\n")
+	}
+	return config.authKey()
+}
+
+// LoadAuthKeyFromConfig loads the AuthKey from a JSON config file, see
+// ParseAuthKeyJSON for the format. A relative signing_key_file is relative
+// to the directory of the config file.
+func LoadAuthKeyFromConfig(path string) (AuthKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AuthKey{}, err
+	}
+
+	var config authKeyConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return AuthKey{}, fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+
+	if config.SigningKeyFile != "" && !filepath.IsAbs(config.SigningKeyFile) {
+		config.SigningKeyFile = filepath.Join(filepath.Dir(path), config.SigningKeyFile)
+	}
+	return config.authKey()
+}
+
+// ParseAuthKeyJSON parses the AuthKey of a JSON config, such as:
+//
+//	{
+//	  "key_id": "AB12CD34EF",
+//	  "team_id": "GH56IJ78KL",
+//	  "client_id": "com.example.app",
+//	  "signing_key_file": "/secrets/AuthKey_AB12CD34EF.p8"
+//	}
+//
+// The private key is either in signing_key, PEM-encoded, or in the file at
+// signing_key_file.
+func ParseAuthKeyJSON(data []byte) (AuthKey, error) {
+	var config authKeyConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return AuthKey{}, err
+	}
+	return config.authKey()
+}
+
+// authKeyConfig is the configuration of an AuthKey, which unlike AuthKey
+// carries the signing key
+type authKeyConfig struct {
+	KeyID          string `json:"key_id"`
+	ClientID       string `json:"client_id"`
+	TeamID         string `json:"team_id"`
+	SigningKey     string `json:"signing_key"`
+	SigningKeyFile string `json:"signing_key_file"`
+}
+
+func (c *authKeyConfig) authKey() (AuthKey, error) {
+	if c.SigningKey != "" && c.SigningKeyFile != "" {
+		return AuthKey{}, errors.New("signing_key and signing_key_file are mutually exclusive")
+	}
+	signingKey := c.SigningKey
+	if c.SigningKeyFile != "" {
+		data, err := os.ReadFile(c.SigningKeyFile)
+		if err != nil {
+			return AuthKey{}, err
+		}
+		signingKey = string(data)
+	}
+
+	return validAuthKey(AuthKey{
+		KeyID:      c.KeyID,
+		ClientID:   c.ClientID,
+		TeamID:     c.TeamID,
+		SigningKey: Secret(signingKey),
+	})
+}
+
+func validAuthKey(key AuthKey) (AuthKey, error) {
+	if err := key.Validate(); err != nil {
+		return AuthKey{}, fmt.Errorf("invalid auth key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,57 @@
+package apple
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAuthKeyFromConfig(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "AuthKey_AB12CD34EF.p8"), []byte(signingKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inline, err := json.Marshal(map[string]string{"key_id": "AB12CD34EF", "team_id": "GH56IJ78KL", "client_id": "com.example.app", "signing_key": signingKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := map[string]string{
+		"inline.json": string(inline),
+		"file.json":   `{"key_id":"AB12CD34EF","team_id":"GH56IJ78KL","client_id":"com.example.app","signing_key_file":"AuthKey_AB12CD34EF.p8"}`,
+	}
+	for name, content := range configs {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			authKey, err := LoadAuthKeyFromConfig(path)
+			if err != nil {
+				t.Fatalf("LoadAuthKeyFromConfig() error = %v", err)
+			}
+			if authKey.KeyID != "AB12CD34EF" || authKey.TeamID != "GH56IJ78KL" || authKey.ClientID != "com.example.app" {
+				t.Errorf("LoadAuthKeyFromConfig() = %+v", authKey)
+			}
+			if parsed, err := parseSigningKey(authKey.SigningKey); err != nil || !parsed.Equal(key) {
+				t.Errorf("signing key = %v, %v, want the key of the config", parsed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling Apple while the circuit breaker
+// of an endpoint is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrTooManyInFlight is returned without calling Apple when an endpoint
+// already has the maximum number of requests in flight.
+var ErrTooManyInFlight = errors.New("too many requests in flight")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // Requests go through, failures are counted.
+	CircuitOpen                         // Requests fail fast with ErrCircuitOpen.
+	CircuitHalfOpen                     // A limited number of probe requests go through.
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// BreakerPolicy configures the circuit breaker and the bulkhead which guard
+// every endpoint of the client. Each endpoint has its own breaker and its
+// own in-flight limit.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit. Zero disables the circuit breaker.
+	FailureThreshold int
+
+	// OpenTimeout is how long the circuit stays open before letting probe
+	// requests through.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of probe requests allowed at the same time
+	// while the circuit is half-open, all of them must succeed to close the
+	// circuit. Defaults to 1.
+	HalfOpenProbes int
+
+	// MaxInFlight is the maximum number of requests in flight per endpoint,
+	// requests above the limit fail fast with ErrTooManyInFlight. Zero means
+	// no limit.
+	MaxInFlight int
+
+	// OnStateChange is called whenever the circuit of an endpoint changes its
+	// state. It is called synchronously and must not block.
+	OnStateChange func(endpoint Endpoint, from, to CircuitState)
+}
+
+// DefaultBreakerPolicy returns a policy which opens the circuit after 5
+// consecutive failures for 30 seconds, and allows 32 requests in flight per
+// endpoint.
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenProbes:   1,
+		MaxInFlight:      32,
+	}
+}
+
+// NewBreakerMiddleware returns a Middleware which guards every endpoint with
+// a circuit breaker and a limit of requests in flight. See WithBreakerPolicy.
+func NewBreakerMiddleware(policy BreakerPolicy) Middleware {
+	b := newBreakers(policy)
+	return func(next Doer) Doer {
+		if !b.enabled() {
+			return next
+		}
+		return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+			release, err := b.acquire(req.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			rsp, err := next.Do(ctx, req)
+			release(isBreakerFailure(statusCodeOf(rsp), err))
+			return rsp, err
+		})
+	}
+}
+
+// breakers holds the circuit breaker and the bulkhead of every endpoint
+type breakers struct {
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	circuits map[Endpoint]*circuit
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probes   int
+	inFlight int
+}
+
+func newBreakers(policy BreakerPolicy) *breakers {
+	if policy.HalfOpenProbes <= 0 {
+		policy.HalfOpenProbes = 1
+	}
+	return &breakers{policy: policy, circuits: make(map[Endpoint]*circuit)}
+}
+
+func (b *breakers) enabled() bool {
+	return (b.policy.FailureThreshold > 0 || b.policy.MaxInFlight > 0)
+}
+
+// acquire asks for a permit to call the endpoint, the returned func must be
+// called with the outcome of the call once it is done
+func (b *breakers) acquire(endpoint Endpoint) (release func(failed bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuits[endpoint]
+	if c == nil {
+		c = &circuit{}
+		b.circuits[endpoint] = c
+	}
+
+	if b.policy.FailureThreshold > 0 {
+		if c.state == CircuitOpen {
+			if time.Since(c.openedAt) < b.policy.OpenTimeout {
+				return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, endpoint)
+			}
+			b.transit(endpoint, c, CircuitHalfOpen)
+		}
+		if c.state == CircuitHalfOpen && c.probes >= b.policy.HalfOpenProbes {
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, endpoint)
+		}
+	}
+	if b.policy.MaxInFlight > 0 && c.inFlight >= b.policy.MaxInFlight {
+		return nil, fmt.Errorf("%w: %s", ErrTooManyInFlight, endpoint)
+	}
+
+	probe := c.state == CircuitHalfOpen
+	if probe {
+		c.probes++
+	}
+	c.inFlight++
+
+	var once sync.Once
+	return func(failed bool) {
+		once.Do(func() { b.release(endpoint, c, probe, failed) })
+	}, nil
+}
+
+func (b *breakers) release(endpoint Endpoint, c *circuit, probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c.inFlight--
+	if probe {
+		c.probes--
+	}
+	if b.policy.FailureThreshold <= 0 {
+		return
+	}
+
+	switch {
+	case failed && c.state == CircuitHalfOpen:
+		b.transit(endpoint, c, CircuitOpen)
+	case failed && c.state == CircuitClosed:
+		c.failures++
+		if c.failures >= b.policy.FailureThreshold {
+			b.transit(endpoint, c, CircuitOpen)
+		}
+	case !failed && c.state == CircuitHalfOpen:
+		if probe && c.probes == 0 {
+			b.transit(endpoint, c, CircuitClosed)
+		}
+	case !failed:
+		c.failures = 0
+	}
+}
+
+func (b *breakers) transit(endpoint Endpoint, c *circuit, to CircuitState) {
+	from := c.state
+	c.state = to
+	c.failures = 0
+	if to == CircuitOpen {
+		c.openedAt = time.Now()
+	}
+	if b.policy.OnStateChange != nil && from != to {
+		b.policy.OnStateChange(endpoint, from, to)
+	}
+}
+
+// isBreakerFailure reports whether the outcome of a call counts as a failure
+// of Apple, client errors such as invalid_grant do not
+func isBreakerFailure(statusCode int, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
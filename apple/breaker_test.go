@@ -0,0 +1,130 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBreakerStateMachine(t *testing.T) {
+	var transitions []CircuitState
+	b := newBreakers(BreakerPolicy{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenProbes:   1,
+		OnStateChange: func(_ Endpoint, _, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	})
+	call := func(failed bool) error {
+		release, err := b.acquire(EndpointToken)
+		if err == nil {
+			release(failed)
+		}
+		return err
+	}
+	state := func() CircuitState {
+		return b.circuits[EndpointToken].state
+	}
+
+	// a success resets the consecutive failures
+	_ = call(true)
+	_ = call(false)
+	_ = call(true)
+	if state() != CircuitClosed {
+		t.Fatalf("state = %s after non-consecutive failures, want closed", state())
+	}
+
+	// consecutive failures open the circuit
+	_ = call(true)
+	if state() != CircuitOpen {
+		t.Fatalf("state = %s after 2 consecutive failures, want open", state())
+	}
+	if err := call(false); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("call while open error = %v, want ErrCircuitOpen", err)
+	}
+
+	// a failed probe opens the circuit again
+	time.Sleep(30 * time.Millisecond)
+	if err := call(true); err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	if state() != CircuitOpen {
+		t.Fatalf("state = %s after a failed probe, want open", state())
+	}
+
+	// the probes are limited while half-open, a successful one closes it
+	time.Sleep(30 * time.Millisecond)
+	release, err := b.acquire(EndpointToken)
+	if err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	if state() != CircuitHalfOpen {
+		t.Fatalf("state = %s during a probe, want half-open", state())
+	}
+	if err = call(false); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe error = %v, want ErrCircuitOpen", err)
+	}
+	release(false)
+	if state() != CircuitClosed {
+		t.Fatalf("state = %s after a successful probe, want closed", state())
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestBreakerEndpointsAreIndependent(t *testing.T) {
+	b := newBreakers(BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute})
+	release, _ := b.acquire(EndpointToken)
+	release(true)
+
+	if _, err := b.acquire(EndpointToken); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("token endpoint error = %v, want ErrCircuitOpen", err)
+	}
+	if _, err := b.acquire(EndpointRevoke); err != nil {
+		t.Errorf("revoke endpoint error = %v, want nil", err)
+	}
+}
+
+func TestBreakerMaxInFlight(t *testing.T) {
+	b := newBreakers(BreakerPolicy{MaxInFlight: 2})
+	first, _ := b.acquire(EndpointRevoke)
+	_, _ = b.acquire(EndpointRevoke)
+	if _, err := b.acquire(EndpointRevoke); !errors.Is(err, ErrTooManyInFlight) {
+		t.Fatalf("third request error = %v, want ErrTooManyInFlight", err)
+	}
+	first(false)
+	first(false) // released once only
+	if _, err := b.acquire(EndpointRevoke); err != nil {
+		t.Errorf("request after a release error = %v, want nil", err)
+	}
+	if _, err := b.acquire(EndpointRevoke); !errors.Is(err, ErrTooManyInFlight) {
+		t.Errorf("request above the limit error = %v, want ErrTooManyInFlight", err)
+	}
+}
+
+func TestBreakerMiddlewareCountsServerErrorsOnly(t *testing.T) {
+	server, requests := newStatusServer(t, nil,
+		http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest,
+		http.StatusBadGateway, http.StatusServiceUnavailable)
+	doer := NewBreakerMiddleware(BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute})(httpDoer(server))
+	req := &Request{Method: http.MethodPost, Endpoint: EndpointRevoke}
+
+	for i := 0; i < 5; i++ {
+		if _, err := doer.Do(context.Background(), req); err != nil {
+			t.Fatalf("request %d error = %v", i+1, err)
+		}
+	}
+	if _, err := doer.Do(context.Background(), req); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("request after 2 server errors error = %v, want ErrCircuitOpen", err)
+	}
+	if n := requests.Load(); n != 5 {
+		t.Errorf("requests = %d, want 5", n)
+	}
+}
@@ -3,64 +3,88 @@ package apple
 import (
 	"context"
 	"errors"
+
+	"github.com/go-resty/resty/v2"
 )
 
-func (c *client) ObtainMigrationAccessToken(ctx context.Context, clientID, clientSecret string) (rsp *TokenResponse, err error) {
+func (c *client) ObtainMigrationAccessToken(ctx context.Context, clientID string, clientSecret Secret) (rsp *TokenResponse, err error) {
 	formData := map[string]string{
 		"client_id":     clientID,
-		"client_secret": clientSecret,
+		"client_secret": clientSecret.Reveal(),
 		"scope":         "user.migration",
 		"grant_type":    "client_credentials",
 	}
+	defer func() {
+		c.audit(ctx, AuditRecord{Action: AuditMigrationToken, ClientID: clientID}, err)
+	}()
 	return c.doRequestValidation(ctx, formData)
 }
 
-func (c *client) GenerateTransferSub(ctx context.Context, clientID, recipientTeamID, clientSecret, accessToken, sub string) (transferSub string, err error) {
+func (c *client) GenerateTransferSub(ctx context.Context, clientID, recipientTeamID string, clientSecret, accessToken Secret, sub string) (transferSub string, err error) {
 	formData := map[string]string{
 		"client_id":     clientID,
-		"client_secret": clientSecret,
+		"client_secret": clientSecret.Reveal(),
 		"sub":           sub,
 		"target":        recipientTeamID,
 	}
+	defer func() {
+		c.audit(ctx, AuditRecord{
+			Action:           AuditGenerateTransferSub,
+			Subject:          sub,
+			ClientID:         clientID,
+			Target:           recipientTeamID,
+			TokenFingerprint: TokenFingerprint(accessToken),
+		}, err)
+	}()
 
 	rsp := &GenerateTransferSubResponse{}
 
-	_, err = c.client.R().
-		SetContext(ctx).
-		SetHeader(headerAuthorization, "Bearer "+accessToken).
-		SetFormData(formData).
-		SetResult(rsp).
-		Post(userMigrationURI)
+	header := map[string]string{headerAuthorization: "Bearer " + accessToken.Reveal()}
+	raw, err := c.request(ctx, resty.MethodPost, EndpointMigration, header, formData, rsp)
 	if err != nil {
 		return "", err
 	}
-	if rsp == nil {
-		return "", errors.New("no response")
+	if err = newMigrationError(raw, rsp.Error, rsp.ErrorDescription); err != nil {
+		return "", err
+	}
+	if rsp.TransferSub == "" {
+		return "", errors.New("no transfer_sub in Apple's response")
 	}
 
 	return rsp.TransferSub, nil
 }
 
-func (c *client) ExchangeIdentifier(ctx context.Context, clientID, clientSecret, accessToken, transferSub string) (rsp *ExchangeIdentifierResponse, err error) {
+func (c *client) ExchangeIdentifier(ctx context.Context, clientID string, clientSecret, accessToken Secret, transferSub string) (rsp *ExchangeIdentifierResponse, err error) {
 	formData := map[string]string{
 		"client_id":     clientID,
-		"client_secret": clientSecret,
+		"client_secret": clientSecret.Reveal(),
 		"transfer_sub":  transferSub,
 	}
+	defer func() {
+		record := AuditRecord{
+			Action:           AuditExchangeIdentifier,
+			Subject:          transferSub,
+			ClientID:         clientID,
+			TokenFingerprint: TokenFingerprint(accessToken),
+		}
+		if rsp != nil {
+			record.Target = rsp.Sub
+		}
+		c.audit(ctx, record, err)
+	}()
 
 	rsp = &ExchangeIdentifierResponse{}
 
-	_, err = c.client.R().
-		SetContext(ctx).
-		SetHeader(headerAuthorization, "Bearer "+accessToken).
-		SetFormData(formData).
-		SetResult(rsp).
-		Post(userMigrationURI)
+	header := map[string]string{headerAuthorization: "Bearer " + accessToken.Reveal()}
+	raw, err := c.request(ctx, resty.MethodPost, EndpointMigration, header, formData, rsp)
 	if err != nil {
 		return nil, err
 	}
-	if rsp == nil {
-		return nil, errors.New("no response")
+	if err = newMigrationError(raw, rsp.Error, rsp.ErrorDescription); err != nil {
+		return nil, err
+	}
+	if rsp.Sub == "" {
+		return nil, errors.New("no sub in Apple's response")
 	}
 
 	return rsp, nil
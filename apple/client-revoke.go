@@ -2,44 +2,132 @@ package apple
 
 import (
 	"context"
+	"errors"
 	"fmt"
+
+	"github.com/go-resty/resty/v2"
 )
 
-func (c *client) RevokeAccessToken(ctx context.Context, clientID, clientSecret, accessToken string) (rsp *RevokeResponse, err error) {
+func (c *client) RevokeAccessToken(ctx context.Context, clientID string, clientSecret, accessToken Secret) (rsp *RevokeResponse, err error) {
 	formData := map[string]string{
 		"client_id":       clientID,
-		"client_secret":   clientSecret,
-		"token":           accessToken,
+		"client_secret":   clientSecret.Reveal(),
+		"token":           accessToken.Reveal(),
 		"token_type_hint": "access_token",
 	}
 	return c.doRequestRevoke(ctx, formData)
 }
 
-func (c *client) RevokeRefreshToken(ctx context.Context, clientID, clientSecret, refreshToken string) (rsp *RevokeResponse, err error) {
+func (c *client) RevokeRefreshToken(ctx context.Context, clientID string, clientSecret, refreshToken Secret) (rsp *RevokeResponse, err error) {
 	formData := map[string]string{
 		"client_id":       clientID,
-		"client_secret":   clientSecret,
-		"token":           refreshToken,
+		"client_secret":   clientSecret.Reveal(),
+		"token":           refreshToken.Reveal(),
 		"token_type_hint": "refresh_token",
 	}
 	return c.doRequestRevoke(ctx, formData)
 }
 
+func (c *client) RevokeToken(ctx context.Context, req RevokeRequest) (rsp *RevokeResponse, err error) {
+	hints, err := revokeHints(req.TypeHint, req.TryOtherHint)
+	if err != nil {
+		return &RevokeResponse{TypeHint: req.TypeHint, Outcome: RevocationFailed}, err
+	}
+
+	for _, hint := range hints {
+		formData := map[string]string{
+			"client_id":     req.ClientID,
+			"client_secret": req.ClientSecret.Reveal(),
+			"token":         req.Token.Reveal(),
+		}
+		if hint != "" {
+			formData["token_type_hint"] = string(hint)
+		}
+
+		rsp, err = c.doRequestRevoke(ctx, formData)
+		if rsp == nil {
+			rsp = revokeErrorResponse(err)
+		}
+		rsp.TypeHint = hint
+
+		if !isHintRejected(rsp.Error) {
+			break
+		}
+	}
+
+	rsp.Outcome = revocationOutcome(err)
+	if rsp.Outcome == RevocationFailed {
+		return rsp, err
+	}
+	return rsp, nil
+}
+
+// revokeHints returns the token_type_hint values to try in turn to revoke a
+// token of the given type
+func revokeHints(hint TokenTypeHint, tryOther bool) ([]TokenTypeHint, error) {
+	switch hint {
+	case "":
+		if tryOther {
+			return []TokenTypeHint{TokenTypeRefreshToken, TokenTypeAccessToken}, nil
+		}
+	case TokenTypeAccessToken:
+		if tryOther {
+			return []TokenTypeHint{hint, TokenTypeRefreshToken}, nil
+		}
+	case TokenTypeRefreshToken:
+		if tryOther {
+			return []TokenTypeHint{hint, TokenTypeAccessToken}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown token type hint %q", hint)
+	}
+	return []TokenTypeHint{hint}, nil
+}
+
+// revokeErrorResponse returns the RevokeResponse of a failed revocation
+func revokeErrorResponse(err error) *RevokeResponse {
+	rsp := &RevokeResponse{}
+	var e *Error
+	if errors.As(err, &e) {
+		rsp.StatusCode = e.StatusCode
+		rsp.Error = e.Code
+		rsp.ErrorDescription = e.Description
+	}
+	return rsp
+}
+
+// isHintRejected reports whether Apple may have rejected the token because
+// of its token_type_hint, from the error code of the response
+func isHintRejected(code string) bool {
+	switch code {
+	case "invalid_grant", "invalid_request", "unsupported_token_type":
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *client) doRequestRevoke(ctx context.Context, formData map[string]string) (rsp *RevokeResponse, err error) {
+	defer func() {
+		c.audit(ctx, AuditRecord{
+			Action:           AuditRevokeToken,
+			ClientID:         formData["client_id"],
+			TokenType:        TokenTypeHint(formData["token_type_hint"]),
+			TokenFingerprint: TokenFingerprint(Secret(formData["token"])),
+			Outcome:          string(revocationOutcome(err)),
+		}, err)
+	}()
+
 	rsp = &RevokeResponse{}
 
-	_, err = c.client.R().
-		SetContext(ctx).
-		SetFormData(formData).
-		SetResult(rsp).
-		Post(revokeURI)
+	raw, err := c.request(ctx, resty.MethodPost, EndpointRevoke, nil, formData, rsp)
 	if err != nil {
 		return nil, err
 	}
 
-	if rsp.Error != "" {
-		return nil, fmt.Errorf("error %q: %s", rsp.Error, rsp.ErrorDescription)
+	rsp.StatusCode = raw.StatusCode
+	if err = newError(raw, rsp.Error, rsp.ErrorDescription); err != nil {
+		return rsp, err
 	}
-
 	return rsp, nil
 }
@@ -0,0 +1,127 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRevokeToken(t *testing.T) {
+	const (
+		ok            = `ok`
+		invalidGrant  = `{"error":"invalid_grant"}`
+		unsupported   = `{"error":"unsupported_token_type"}`
+		invalidClient = `{"error":"invalid_client"}`
+	)
+
+	tests := []struct {
+		name         string
+		req          RevokeRequest
+		answers      map[string]string // the answer to each token_type_hint
+		wantHints    []string
+		wantOutcome  RevocationOutcome
+		wantTypeHint TokenTypeHint
+		wantStatus   int
+		wantErr      bool
+	}{
+		{
+			name:         "revoked",
+			req:          RevokeRequest{TypeHint: TokenTypeRefreshToken},
+			answers:      map[string]string{"refresh_token": ok},
+			wantHints:    []string{"refresh_token"},
+			wantOutcome:  RevocationRevoked,
+			wantTypeHint: TokenTypeRefreshToken,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "already invalid",
+			req:          RevokeRequest{TypeHint: TokenTypeRefreshToken},
+			answers:      map[string]string{"refresh_token": invalidGrant},
+			wantHints:    []string{"refresh_token"},
+			wantOutcome:  RevocationAlreadyInvalid,
+			wantTypeHint: TokenTypeRefreshToken,
+			wantStatus:   http.StatusBadRequest,
+		},
+		{
+			name:         "other hint after invalid_grant",
+			req:          RevokeRequest{TypeHint: TokenTypeRefreshToken, TryOtherHint: true},
+			answers:      map[string]string{"refresh_token": invalidGrant, "access_token": ok},
+			wantHints:    []string{"refresh_token", "access_token"},
+			wantOutcome:  RevocationRevoked,
+			wantTypeHint: TokenTypeAccessToken,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "other hint after unsupported_token_type",
+			req:          RevokeRequest{TypeHint: TokenTypeAccessToken, TryOtherHint: true},
+			answers:      map[string]string{"access_token": unsupported, "refresh_token": ok},
+			wantHints:    []string{"access_token", "refresh_token"},
+			wantOutcome:  RevocationRevoked,
+			wantTypeHint: TokenTypeRefreshToken,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "already invalid with both hints",
+			req:          RevokeRequest{TryOtherHint: true},
+			answers:      map[string]string{"refresh_token": invalidGrant, "access_token": invalidGrant},
+			wantHints:    []string{"refresh_token", "access_token"},
+			wantOutcome:  RevocationAlreadyInvalid,
+			wantTypeHint: TokenTypeAccessToken,
+			wantStatus:   http.StatusBadRequest,
+		},
+		{
+			name:        "no hint",
+			req:         RevokeRequest{},
+			answers:     map[string]string{"": ok},
+			wantHints:   []string{""},
+			wantOutcome: RevocationRevoked,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:         "other error is not retried with the other hint",
+			req:          RevokeRequest{TypeHint: TokenTypeRefreshToken, TryOtherHint: true},
+			answers:      map[string]string{"refresh_token": invalidClient},
+			wantHints:    []string{"refresh_token"},
+			wantOutcome:  RevocationFailed,
+			wantTypeHint: TokenTypeRefreshToken,
+			wantStatus:   http.StatusBadRequest,
+			wantErr:      true,
+		},
+		{
+			name:         "unknown hint",
+			req:          RevokeRequest{TypeHint: "id_token"},
+			wantOutcome:  RevocationFailed,
+			wantTypeHint: "id_token",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hints []string
+			c := newStubClient(t, func(req *Request) *Response {
+				hint := req.Form["token_type_hint"]
+				hints = append(hints, hint)
+				if answer := tt.answers[hint]; answer != ok {
+					return &Response{StatusCode: http.StatusBadRequest, Body: []byte(answer)}
+				}
+				return &Response{StatusCode: http.StatusOK}
+			})
+
+			tt.req.ClientID, tt.req.ClientSecret, tt.req.Token = "com.example.app", "secret", "t1"
+			rsp, err := c.RevokeToken(context.Background(), tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RevokeToken() error = %v, want error %t", err, tt.wantErr)
+			}
+			if rsp == nil {
+				t.Fatal("RevokeToken() response = nil")
+			}
+			if rsp.Outcome != tt.wantOutcome || rsp.TypeHint != tt.wantTypeHint || rsp.StatusCode != tt.wantStatus {
+				t.Errorf("RevokeToken() = %+v, want outcome %s, hint %q and status %d", rsp, tt.wantOutcome, tt.wantTypeHint, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(hints, tt.wantHints) {
+				t.Errorf("hints = %q, want %q", hints, tt.wantHints)
+			}
+		})
+	}
+}
@@ -3,63 +3,97 @@ package apple
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/go-resty/resty/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/cast"
 )
 
 func (c *client) VerifyTokenSignature(idToken string) (pass bool, token *jwt.Token, err error) {
+	reason := ""
+	defer func() {
+		_, updateAt := c.publicKeys()
+		c.metrics.TokenVerified(reason, time.Since(updateAt))
+		if reason != "" {
+			c.logger.Warn("failed to verify id_token", slog.String("reason", reason), slog.Any("error", err))
+		} else {
+			c.logger.Debug("verified id_token", slog.String("kid", cast.ToString(token.Header["kid"])))
+		}
+	}()
+
 	if idToken == "" {
+		reason = VerifyFailureEmptyToken
 		return false, nil, errors.New("idToken is required, must not be empty")
 	}
 
 	token, _, err = jwt.NewParser().ParseUnverified(idToken, jwt.MapClaims{})
 	if err != nil {
+		reason = VerifyFailureMalformed
 		return false, token, err
 	}
 
-	keyID := token.Header["kid"].(string)
-	audience := token.Header["aud"].([]string)[0]
-	subject := token.Header["sub"].(string)
+	keyID, _ := token.Header["kid"].(string)
 
 	pubkey, err := c.loadApplePublicKey(keyID)
+	if errors.Is(err, errMissingPublicKey) && keyID != "" && c.refreshPublicKeys() {
+		pubkey, err = c.loadApplePublicKey(keyID)
+	}
 	if err != nil {
+		reason = VerifyFailureUnknownKey
 		return false, token, err
 	}
 
-	claims := jwt.MapClaims{
-		"iss": "https://appleid.apple.com",
-		"aud": audience,
-		"sub": subject,
-	}
-
-	token, err = jwt.ParseWithClaims(idToken, claims, func(_ *jwt.Token) (interface{}, error) {
+	token, err = jwt.ParseWithClaims(idToken, jwt.MapClaims{}, func(_ *jwt.Token) (interface{}, error) {
 		return pubkey, nil
 	})
 	if err != nil {
+		reason = verifyFailureReason(err)
 		return false, token, err
 	}
 	if token == nil {
+		reason = VerifyFailureMalformed
 		return false, token, errors.New("token is nil")
 	}
+	if claims, _ := token.Claims.(jwt.MapClaims); !claims.VerifyIssuer(baseURL, true) {
+		reason = VerifyFailureInvalidIssuer
+		return false, token, jwt.ErrTokenInvalidIssuer
+	}
+	if len(c.audience) > 0 && !c.verifyAudience(token) {
+		reason = VerifyFailureInvalidAudience
+		return false, token, jwt.ErrTokenInvalidAudience
+	}
 
 	return true, token, nil
 }
 
-func (c *client) ValidateAppToken(ctx context.Context, clientID, clientSecret, code string) (rsp *TokenResponse, err error) {
+// verifyAudience reports whether the aud claim of token is one of the client
+// IDs of the client
+func (c *client) verifyAudience(token *jwt.Token) bool {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	for _, clientID := range c.audience {
+		if claims.VerifyAudience(clientID, true) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *client) ValidateAppToken(ctx context.Context, clientID string, clientSecret Secret, code string) (rsp *TokenResponse, err error) {
 	form := map[string]string{
 		"client_id":     clientID,
-		"client_secret": clientSecret,
+		"client_secret": clientSecret.Reveal(),
 		"code":          code,
 		"grant_type":    "authorization_code",
 	}
 	return c.doRequestValidation(ctx, form)
 }
 
-func (c *client) ValidateWebToken(ctx context.Context, clientID, clientSecret, code, redirectURL string) (rsp *TokenResponse, err error) {
+func (c *client) ValidateWebToken(ctx context.Context, clientID string, clientSecret Secret, code, redirectURL string) (rsp *TokenResponse, err error) {
 	formData := map[string]string{
 		"client_id":     clientID,
-		"client_secret": clientSecret,
+		"client_secret": clientSecret.Reveal(),
 		"code":          code,
 		"redirect_uri":  redirectURL,
 		"grant_type":    "authorization_code",
@@ -67,11 +101,11 @@ func (c *client) ValidateWebToken(ctx context.Context, clientID, clientSecret, c
 	return c.doRequestValidation(ctx, formData)
 }
 
-func (c *client) ValidateRefreshToken(ctx context.Context, clientID, clientSecret, refreshToken string) (rsp *TokenResponse, err error) {
+func (c *client) ValidateRefreshToken(ctx context.Context, clientID string, clientSecret, refreshToken Secret) (rsp *TokenResponse, err error) {
 	formData := map[string]string{
 		"client_id":     clientID,
-		"client_secret": clientSecret,
-		"refresh_token": refreshToken,
+		"client_secret": clientSecret.Reveal(),
+		"refresh_token": refreshToken.Reveal(),
 		"grant_type":    "refresh_token",
 	}
 	return c.doRequestValidation(ctx, formData)
@@ -80,17 +114,13 @@ func (c *client) ValidateRefreshToken(ctx context.Context, clientID, clientSecre
 func (c *client) doRequestValidation(ctx context.Context, formData map[string]string) (rsp *TokenResponse, err error) {
 	rsp = &TokenResponse{}
 
-	_, err = c.client.R().
-		SetContext(ctx).
-		SetFormData(formData).
-		SetResult(rsp).
-		Post(validationURI)
+	raw, err := c.request(ctx, resty.MethodPost, EndpointToken, nil, formData, rsp)
 	if err != nil {
 		return nil, err
 	}
 
-	if rsp.Error != "" {
-		return nil, fmt.Errorf("error %q: %s", rsp.Error, rsp.ErrorDescription)
+	if err = newError(raw, rsp.Error, rsp.ErrorDescription); err != nil {
+		return nil, err
 	}
 
 	return rsp, nil
@@ -4,9 +4,13 @@ import (
 	"context"
 	"crypto/rsa"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/big"
+	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -31,9 +35,29 @@ const (
 	headerValueAccept      = `application/json`
 )
 
+// Endpoint identifies a Sign in with Apple REST endpoint
+type Endpoint string
+
+const (
+	EndpointKeys      Endpoint = applePublicKeyURI // Fetch Apple's public key
+	EndpointToken     Endpoint = validationURI     // Generate and validate tokens
+	EndpointRevoke    Endpoint = revokeURI         // Revoke tokens
+	EndpointMigration Endpoint = userMigrationURI  // Transfer users across teams
+)
+
 type Client interface {
 	// VerifyTokenSignature for verifying the ID token signature
 	//
+	// It checks the signature with Apple's public keys, the expiry and the
+	// issuer of the token. The audience is checked only if the client is
+	// created WithAudience, otherwise an id_token issued for any app passes,
+	// and the caller must check that the aud claim is its own client ID.
+	// This is a breaking change: the client has no client ID of its own, so
+	// the audience is not checked by default.
+	//
+	// A token signed with a key unknown yet fetches Apple's public keys
+	// again, once a minute at most.
+	//
 	// Ref: https://developer.apple.com/documentation/sign_in_with_apple/processing-changes-for-sign-in-with-apple-accounts#Decode-and-validate-the-notifications
 	VerifyTokenSignature(idToken string) (pass bool, token *jwt.Token, err error)
 
@@ -52,7 +76,7 @@ type Client interface {
 	//
 	// @param code: The authorization code received in an authorization response
 	// sent to your app. The code is single-use only and valid for five minutes.
-	ValidateAppToken(ctx context.Context, clientID, clientSecret, code string) (*TokenResponse, error)
+	ValidateAppToken(ctx context.Context, clientID string, clientSecret Secret, code string) (*TokenResponse, error)
 
 	// ValidateWebToken sends the validation request and gets TokenResponse
 	//
@@ -74,7 +98,7 @@ type Client interface {
 	// request when authorizing a user with your app, if applicable. The URI
 	// must use the HTTPS protocol, include a domain name, and can’t contain
 	// an IP address or localhost.
-	ValidateWebToken(ctx context.Context, clientID, clientSecret, code, redirectURL string) (*TokenResponse, error)
+	ValidateWebToken(ctx context.Context, clientID string, clientSecret Secret, code, redirectURL string) (*TokenResponse, error)
 
 	// ValidateRefreshToken sends the validation request and gets TokenResponse
 	//
@@ -91,7 +115,7 @@ type Client interface {
 	//
 	// @param refreshToken: The refresh token received from the validation
 	// server during an authorization request.
-	ValidateRefreshToken(ctx context.Context, clientID, clientSecret, refreshToken string) (*TokenResponse, error)
+	ValidateRefreshToken(ctx context.Context, clientID string, clientSecret, refreshToken Secret) (*TokenResponse, error)
 
 	// RevokeAccessToken revokes the access_token
 	//
@@ -108,7 +132,7 @@ type Client interface {
 	// @param accessToken: The user access token intended to be revoked. The user
 	// session associated with the token provided is revoked if the request is
 	// successful.
-	RevokeAccessToken(ctx context.Context, clientID, clientSecret, accessToken string) (*RevokeResponse, error)
+	RevokeAccessToken(ctx context.Context, clientID string, clientSecret, accessToken Secret) (*RevokeResponse, error)
 
 	// RevokeRefreshToken revokes the refresh_token
 	//
@@ -125,7 +149,18 @@ type Client interface {
 	// @param refreshToken: The user refresh token intended to be revoked. The user
 	// session associated with the token provided is revoked if the request is
 	// successful.
-	RevokeRefreshToken(ctx context.Context, clientID, clientSecret, refreshToken string) (*RevokeResponse, error)
+	RevokeRefreshToken(ctx context.Context, clientID string, clientSecret, refreshToken Secret) (*RevokeResponse, error)
+
+	// RevokeToken revokes a token whose type may be unknown
+	//
+	// The returned RevokeResponse is never nil, its Outcome tells whether the
+	// token is revoked, already invalid or failed to be revoked, in which case
+	// an error is returned as well. A token that is already expired or revoked
+	// is not an error.
+	//
+	// @param req: The token to revoke, and how to hint its type. See
+	// RevokeRequest.
+	RevokeToken(ctx context.Context, req RevokeRequest) (*RevokeResponse, error)
 
 	// ObtainMigrationAccessToken generates an access_key for migrating users
 	//
@@ -133,16 +168,20 @@ type Client interface {
 	// and generate a transfer identifier. You normally obtain the user access
 	// token when your user signs in, or when you validate a stored refresh token.
 	//
-	// @param clientID: The identifier (App ID or Services ID) for the transferring
-	// app. The identifier must not include your Team ID, to help mitigate the
-	// possibility of exposing sensitive data to the end user.
+	// Both teams obtain their own access token, with their own client ID and
+	// client secret: the transferring team to generate the transfer
+	// identifiers, and the recipient team to exchange them.
 	//
-	// @param clientSecret: The client secret of the transferring team, represented
-	// as a JSON Web Token (JWT). The JWT payload should contain a `sub` claim that
-	// matches the transferring app’s bundle ID or associated Services ID.
+	// @param clientID: The identifier (App ID or Services ID) for the app in
+	// the team. The identifier must not include your Team ID, to help mitigate
+	// the possibility of exposing sensitive data to the end user.
+	//
+	// @param clientSecret: The client secret of the team, represented as a JSON
+	// Web Token (JWT). The JWT payload should contain a `sub` claim that matches
+	// the app’s bundle ID or associated Services ID in the team.
 	//
 	// Ref: https://developer.apple.com/documentation/sign_in_with_apple/transferring-your-apps-and-users-to-another-team#Obtain-the-user-access-token
-	ObtainMigrationAccessToken(ctx context.Context, clientID, clientSecret string) (rsp *TokenResponse, err error)
+	ObtainMigrationAccessToken(ctx context.Context, clientID string, clientSecret Secret) (rsp *TokenResponse, err error)
 
 	// GenerateTransferSub generates a transfer identifier that can transfer user
 	// from last team to a new recipient
@@ -161,40 +200,67 @@ type Client interface {
 	// @param recipientTeamID: The Team ID of the recipient team to which you
 	// transfer the application.
 	//
-	// @param accessToken: The migration access_token obtained by recipient team.
+	// @param accessToken: The migration access_token obtained by the
+	// transferring team, with the same client ID and client secret.
 	//
 	// @param sub: The team-scoped user identifier that Apple provides.
 	//
+	// A rejected transfer returns an *Error wrapping one of ErrInvalidSubject,
+	// ErrTransferExpired, ErrInvalidAccessToken, ErrInvalidClient or
+	// ErrServerError.
+	//
 	// Ref: https://developer.apple.com/documentation/sign_in_with_apple/transferring-your-apps-and-users-to-another-team#Generate-the-transfer-identifier
-	GenerateTransferSub(ctx context.Context, clientID, recipientTeamID, clientSecret, accessToken, sub string) (transferSub string, err error)
+	GenerateTransferSub(ctx context.Context, clientID, recipientTeamID string, clientSecret, accessToken Secret, sub string) (transferSub string, err error)
 
 	// ExchangeIdentifier exchanges identifier of the user in recipient team
 	//
-	// @param clientID: The identifier (App ID or Services ID) for the transferring
-	// app. The identifier must not include your Team ID, to help mitigate the
-	// possibility of exposing sensitive data to the end user.
+	// @param clientID: The identifier (App ID or Services ID) for the app in
+	// the recipient team. The identifier must not include your Team ID, to help
+	// mitigate the possibility of exposing sensitive data to the end user.
 	//
-	// @param clientSecret: The client secret of the transferring team, represented
+	// @param clientSecret: The client secret of the recipient team, represented
 	// as a JSON Web Token (JWT). The JWT payload should contain a `sub` claim that
-	// matches the transferring app’s bundle ID or associated Services ID.
+	// matches the recipient app’s bundle ID or associated Services ID.
 	//
-	// @param accessToken: The migration access_token obtained by recipient team.
+	// @param accessToken: The migration access_token obtained by the recipient
+	// team, with the same client ID and client secret.
 	//
 	// @param transferSub: The transfer identifier that you obtained from the
 	// sending team.
 	//
+	// A rejected exchange returns an *Error wrapping one of ErrInvalidSubject,
+	// ErrTransferExpired, ErrInvalidAccessToken, ErrInvalidClient or
+	// ErrServerError.
+	//
 	// Ref: https://developer.apple.com/documentation/sign_in_with_apple/bringing-new-apps-and-users-into-your-team#Exchange-identifiers
-	ExchangeIdentifier(ctx context.Context, clientID, clientSecret, accessToken, transferSub string) (rsp *ExchangeIdentifierResponse, err error)
+	ExchangeIdentifier(ctx context.Context, clientID string, clientSecret, accessToken Secret, transferSub string) (rsp *ExchangeIdentifierResponse, err error)
 }
 
 type client struct {
 	client *resty.Client
 
-	ticker         *time.Ticker
-	pubkey         *JWKSet
-	pubkeyUpdateAt time.Time
+	ticker          *time.Ticker
+	pubkeyMu        sync.RWMutex // guards pubkey, pubkeyUpdateAt and pubkeyRefreshAt, updated in background
+	pubkey          *JWKSet
+	pubkeyUpdateAt  time.Time
+	pubkeyRefreshAt time.Time // when the keys were last fetched for an unknown key ID
 
 	onUpdatePubkeyFailed func()
+	metrics              Metrics
+	logger               *slog.Logger
+	auditSink            AuditSink
+	audience             []string
+
+	// the chain of middlewares wrapping every request to Apple, from the
+	// outermost to the innermost
+	middlewares []Middleware
+	measure     Middleware
+	retry       Middleware
+	log         Middleware
+	limiter     Middleware
+	breaker     Middleware
+	exchange    Middleware
+	doer        Doer
 
 	closed atomic.Bool
 	stop   chan bool
@@ -205,6 +271,8 @@ func NewClient(opts ...Option) (Client, error) {
 		ticker:               time.NewTicker(32 * time.Minute),
 		stop:                 make(chan bool),
 		onUpdatePubkeyFailed: func() {},
+		metrics:              NopMetrics{},
+		logger:               slog.New(slog.DiscardHandler),
 	}
 
 	for _, opt := range opts {
@@ -218,11 +286,14 @@ func NewClient(opts ...Option) (Client, error) {
 	c.client.SetHeader(headerContentType, headerValueContentType)
 	c.client.SetHeader(headerUserAgent, headerValueUserAgent)
 
+	middlewares := append(c.middlewares, c.measure, c.retry, c.log, c.limiter, c.breaker, c.exchange)
+	c.doer = Chain(middlewares...)(DoerFunc(c.transport))
+
 	// fetch Apple's public key
 	if err := c.fetchApplePublicKey(); err != nil {
 		return nil, fmt.Errorf("cannot create Sign in with Apple client cause error when fetching Apple's public key: %w", err)
 	}
-	if c.pubkey == nil || len(c.pubkey.Keys) == 0 {
+	if set, _ := c.publicKeys(); set == nil || len(set.Keys) == 0 {
 		return nil, errors.New("cannot create Sign in with Apple client caused Apple's public key not found")
 	}
 
@@ -244,11 +315,11 @@ func (c *client) startUpdater() {
 		case <-c.ticker.C:
 			for i := 0; i < 3; i++ {
 				_ = c.fetchApplePublicKey()
-				if c.pubkey != nil && len(c.pubkey.Keys) > 0 {
+				if set, _ := c.publicKeys(); set != nil && len(set.Keys) > 0 {
 					break
 				}
 			}
-			if c.pubkey == nil || len(c.pubkey.Keys) == 0 {
+			if set, _ := c.publicKeys(); set == nil || len(set.Keys) == 0 {
 				c.onUpdatePubkeyFailed()
 			}
 
@@ -263,24 +334,122 @@ func (c *client) startUpdater() {
 // fetch Apple's public key for verifying token signature
 func (c *client) fetchApplePublicKey() (err error) {
 	set := &JWKSet{Keys: make([]*Keys, 0)}
-	_, err = c.client.R().SetResult(set).Get(applePublicKeyURI)
+	_, err = c.request(context.Background(), resty.MethodGet, EndpointKeys, nil, nil, set)
 	if set != nil && len(set.Keys) > 0 {
+		c.pubkeyMu.Lock()
 		c.pubkey = set
 		c.pubkeyUpdateAt = time.Now()
+		c.pubkeyMu.Unlock()
+	}
+	c.metrics.KeysRefreshed(len(set.Keys), err)
+	if err != nil || len(set.Keys) == 0 {
+		c.logger.Error("failed to fetch Apple's public keys", slog.Any("error", err))
+	} else {
+		c.logger.Debug("fetched Apple's public keys", slog.Int("keys", len(set.Keys)))
 	}
 	return err
 }
 
+// request sends a request to Apple through the middlewares, and decodes the
+// response into result
+func (c *client) request(ctx context.Context, method string, endpoint Endpoint, header, formData map[string]string, result any) (*Response, error) {
+	req := &Request{
+		Method:   method,
+		Endpoint: endpoint,
+		Header:   make(http.Header, len(header)),
+		Form:     formData,
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	rsp, err := c.doer.Do(ctx, req)
+	if err != nil {
+		return rsp, err
+	}
+
+	// an error response of Apple is decoded as well, since its error fields
+	// are part of the result models, but it does not have to be valid JSON
+	if result != nil && len(rsp.Body) > 0 {
+		if err = json.Unmarshal(rsp.Body, result); err != nil && rsp.isSuccess() {
+			return rsp, err
+		}
+	}
+
+	return rsp, nil
+}
+
+// transport is the innermost Doer, which actually sends the request
+func (c *client) transport(ctx context.Context, req *Request) (*Response, error) {
+	rsp, err := c.client.R().
+		SetContext(ctx).
+		SetHeaderMultiValues(req.Header).
+		SetFormData(req.Form).
+		Execute(req.Method, string(req.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		StatusCode: rsp.StatusCode(),
+		Header:     rsp.Header(),
+		Body:       rsp.Body(),
+	}, nil
+}
+
+// publicKeys returns Apple's public keys and when they were fetched
+func (c *client) publicKeys() (set *JWKSet, updateAt time.Time) {
+	c.pubkeyMu.RLock()
+	defer c.pubkeyMu.RUnlock()
+	return c.pubkey, c.pubkeyUpdateAt
+}
+
 func (c *client) loadApplePublicKey(keyID string) (pubkey *rsa.PublicKey, err error) {
-	for _, key := range c.pubkey.Keys {
+	set, _ := c.publicKeys()
+	if set == nil {
+		return nil, errMissingPublicKey
+	}
+	for _, key := range set.Keys {
 		if key.KID == keyID {
-			n, _ := base64.StdEncoding.DecodeString(key.N)
-			e, _ := base64.StdEncoding.DecodeString(key.E)
+			// the JWK parameters are base64url encoded without padding
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid modulus of Apple's public key %q: %w", keyID, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exponent of Apple's public key %q: %w", keyID, err)
+			}
 			modulus := new(big.Int).SetBytes(n)
 			publicExponent := int(new(big.Int).SetBytes(e).Int64()) // ensure there's no overflow
 			pubkey = &rsa.PublicKey{N: modulus, E: publicExponent}
 			return pubkey, nil
 		}
 	}
-	return nil, errors.New("missing Apple's public key")
+	return nil, errMissingPublicKey
+}
+
+// errMissingPublicKey means the key a token is signed with is not among
+// Apple's public keys fetched
+var errMissingPublicKey = errors.New("missing Apple's public key")
+
+// publicKeysRefreshInterval is the minimum interval between two fetches of
+// Apple's public keys for tokens signed with an unknown key
+const publicKeysRefreshInterval = time.Minute
+
+// refreshPublicKeys fetches Apple's public keys for a token signed with an
+// unknown key, as Apple may have rotated its keys since the last update. It
+// does so once per publicKeysRefreshInterval at most, so that tokens signed
+// with made-up key IDs cannot flood Apple with requests, and reports whether
+// the keys were fetched.
+func (c *client) refreshPublicKeys() bool {
+	c.pubkeyMu.Lock()
+	if time.Since(c.pubkeyRefreshAt) < publicKeysRefreshInterval {
+		c.pubkeyMu.Unlock()
+		return false
+	}
+	c.pubkeyRefreshAt = time.Now()
+	c.pubkeyMu.Unlock()
+
+	return c.fetchApplePublicKey() == nil
 }
@@ -0,0 +1,28 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// newStubClient creates a client answering every request with respond in
+// place of Apple, Apple's public keys included
+func newStubClient(t *testing.T, respond func(req *Request) *Response, opts ...Option) *client {
+	t.Helper()
+	stub := func(Doer) Doer {
+		return DoerFunc(func(_ context.Context, req *Request) (*Response, error) {
+			if req.Endpoint == EndpointKeys {
+				return &Response{StatusCode: http.StatusOK, Body: []byte(`{"keys":[{"kty":"RSA","kid":"test","n":"AQAB","e":"AQAB"}]}`)}, nil
+			}
+			return respond(req), nil
+		})
+	}
+
+	c, err := NewClient(append(opts, WithMiddleware(stub))...)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(c.(*client).Close)
+	return c.(*client)
+}
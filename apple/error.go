@@ -0,0 +1,125 @@
+package apple
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The kinds of user migration errors, an *Error returned by
+// GenerateTransferSub or ExchangeIdentifier wraps one of them, test it with
+// errors.Is.
+var (
+	// ErrInvalidSubject means the sub or the transfer_sub is unknown to Apple,
+	// or does not belong to the client.
+	ErrInvalidSubject = errors.New("invalid subject")
+
+	// ErrTransferExpired means the transfer window is over: transfer
+	// identifiers can be generated within 60 days after the recipient team
+	// accepts the transfer, and exchanged within 60 days after that.
+	ErrTransferExpired = errors.New("transfer window expired")
+
+	// ErrInvalidAccessToken means the migration access token is missing,
+	// expired or issued to another client. See ObtainMigrationAccessToken.
+	ErrInvalidAccessToken = errors.New("invalid migration access token")
+
+	// ErrInvalidClient means the client ID or the client secret is rejected.
+	ErrInvalidClient = errors.New("invalid client")
+
+	// ErrServerError means Apple failed to handle the request, it may succeed
+	// if it is sent again later.
+	ErrServerError = errors.New("apple server error")
+)
+
+// Error is an error response of Apple.
+type Error struct {
+	StatusCode  int    // The HTTP status code.
+	Code        string // The error code, such as "invalid_grant". It may be empty.
+	Description string // The error description. It may be empty.
+
+	kind error // one of the kinds of user migration errors, if any
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("error %q: %s", e.Code, e.Description)
+}
+
+// Unwrap returns the kind of the error, such as ErrInvalidSubject, if any.
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// Temporary reports whether the request may succeed if it is sent again
+// later, that is when Apple is rate limiting or failing.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// IsErrorCode reports whether err is an Error of Apple with the given code.
+func IsErrorCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// newError returns the Error of an unsuccessful response, or nil if the
+// response is successful and has no error code
+func newError(rsp *Response, code, description string) error {
+	if code == "" && rsp.isSuccess() {
+		return nil
+	}
+	return &Error{StatusCode: rsp.StatusCode, Code: code, Description: description}
+}
+
+// newMigrationError returns the Error of an unsuccessful user migration
+// response, classified by its kind
+func newMigrationError(rsp *Response, code, description string) error {
+	err := newError(rsp, code, description)
+	if err == nil {
+		return nil
+	}
+
+	e := err.(*Error)
+	switch {
+	case code == "invalid_client" || code == "unauthorized_client":
+		e.kind = ErrInvalidClient
+	case code == "invalid_token":
+		e.kind = ErrInvalidAccessToken
+	case code == "invalid_grant" || code == "invalid_request":
+		switch {
+		case isTransferWindowClosed(description):
+			e.kind = ErrTransferExpired
+		case isAccessTokenRejected(description):
+			e.kind = ErrInvalidAccessToken
+		default:
+			e.kind = ErrInvalidSubject
+		}
+	case e.StatusCode == http.StatusUnauthorized:
+		e.kind = ErrInvalidAccessToken
+	case e.StatusCode >= http.StatusInternalServerError:
+		e.kind = ErrServerError
+	case e.StatusCode == http.StatusNotFound:
+		e.kind = ErrInvalidSubject
+	}
+	return e
+}
+
+// isTransferWindowClosed reports whether the error description of Apple
+// says that the transfer window is over, rather than that a token expired
+func isTransferWindowClosed(description string) bool {
+	description = strings.ToLower(description)
+	return strings.Contains(description, "transfer") &&
+		(strings.Contains(description, "window") || strings.Contains(description, "period") || strings.Contains(description, "expired"))
+}
+
+// isAccessTokenRejected reports whether the error description of Apple says
+// that the access token of the request is expired or invalid, rather than
+// the user or the transfer identifier
+func isAccessTokenRejected(description string) bool {
+	description = strings.ToLower(description)
+	return (strings.Contains(description, "access token") || strings.Contains(description, "access_token")) &&
+		(strings.Contains(description, "expired") || strings.Contains(description, "invalid"))
+}
@@ -0,0 +1,41 @@
+package apple
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNewMigrationError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		code        string
+		description string
+		want        error
+	}{
+		{"invalid client with 401", http.StatusUnauthorized, "invalid_client", "", ErrInvalidClient},
+		{"unauthorized client", http.StatusBadRequest, "unauthorized_client", "", ErrInvalidClient},
+		{"invalid token", http.StatusBadRequest, "invalid_token", "", ErrInvalidAccessToken},
+		{"bare 401", http.StatusUnauthorized, "", "", ErrInvalidAccessToken},
+		{"access token expired", http.StatusBadRequest, "invalid_grant", "The access token expired", ErrInvalidAccessToken},
+		{"invalid access token", http.StatusBadRequest, "invalid_request", "Invalid access_token", ErrInvalidAccessToken},
+		{"transfer window over", http.StatusBadRequest, "invalid_grant", "The transfer period has expired", ErrTransferExpired},
+		{"transfer sub expired", http.StatusBadRequest, "invalid_request", "transfer_sub expired", ErrTransferExpired},
+		{"invalid grant", http.StatusBadRequest, "invalid_grant", "", ErrInvalidSubject},
+		{"not found", http.StatusNotFound, "", "", ErrInvalidSubject},
+		{"server error", http.StatusServiceUnavailable, "", "", ErrServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newMigrationError(&Response{StatusCode: tt.status}, tt.code, tt.description)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("newMigrationError() = %v (%v), want %v", err, errors.Unwrap(err), tt.want)
+			}
+		})
+	}
+
+	if err := newMigrationError(&Response{StatusCode: http.StatusOK}, "", ""); err != nil {
+		t.Errorf("newMigrationError() of a success = %v, want nil", err)
+	}
+}
@@ -0,0 +1,156 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = `[REDACTED]`
+
+// sensitiveFields lists the form fields, headers and JSON response fields
+// whose values must never leave the client in plain text.
+var sensitiveFields = map[string]bool{
+	"client_secret": true,
+	"code":          true,
+	"token":         true,
+	"refresh_token": true,
+	"access_token":  true,
+	"id_token":      true,
+	"authorization": true,
+	"signing_key":   true,
+}
+
+func isSensitive(name string) bool {
+	return sensitiveFields[strings.ToLower(name)]
+}
+
+// Exchange is a redacted record of a single HTTP exchange with Apple.
+//
+// Secrets, authorization codes and tokens are replaced with "[REDACTED]" in
+// the request form, the request headers and the JSON response body, so an
+// Exchange is safe to be written into logs.
+type Exchange struct {
+	Time          time.Time         `json:"time"`                     // When the request was sent.
+	Duration      time.Duration     `json:"duration"`                 // How long the exchange took.
+	Method        string            `json:"method"`                   // The HTTP method.
+	URL           string            `json:"url"`                      // The requested URL.
+	RequestHeader http.Header       `json:"request_header,omitempty"` // The request headers set by this exchange.
+	Form          map[string]string `json:"form,omitempty"`           // The request form.
+	StatusCode    int               `json:"status_code,omitempty"`    // The response status code, 0 if no response was received.
+	Header        http.Header       `json:"header,omitempty"`         // The response headers.
+	Body          string            `json:"body,omitempty"`           // The response body.
+	Error         string            `json:"error,omitempty"`          // The transport error, if any.
+}
+
+// ExchangeHook receives a redacted record of every exchange with Apple.
+//
+// The hook is called synchronously in the request path, it should return
+// quickly and must be safe for concurrent use.
+type ExchangeHook func(exchange *Exchange)
+
+// NewJSONLExchangeWriter returns an ExchangeHook which writes every exchange
+// as a single JSON line to w. Writes are serialized, w can be shared by many
+// clients.
+func NewJSONLExchangeWriter(w io.Writer) ExchangeHook {
+	var mu sync.Mutex
+	return func(exchange *Exchange) {
+		line, err := json.Marshal(exchange)
+		if err != nil {
+			return
+		}
+		line = append(line, '\n')
+
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(line)
+	}
+}
+
+// NewExchangeMiddleware returns a Middleware which reports every exchange
+// to the hook. See WithExchangeHook.
+func NewExchangeMiddleware(hook ExchangeHook) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			rsp, err := next.Do(ctx, req)
+			hook(newExchange(start, req, rsp, err))
+			return rsp, err
+		})
+	}
+}
+
+func newExchange(start time.Time, req *Request, rsp *Response, err error) *Exchange {
+	exchange := &Exchange{
+		Time:          start,
+		Duration:      time.Since(start),
+		Method:        req.Method,
+		URL:           baseURL + string(req.Endpoint),
+		RequestHeader: redactHeader(req.Header),
+		Form:          redactValues(req.Form),
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	if rsp != nil {
+		exchange.StatusCode = rsp.StatusCode
+		exchange.Header = rsp.Header.Clone()
+		exchange.Body = redactBody(rsp.Body)
+	}
+	return exchange
+}
+
+func redactHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	out := header.Clone()
+	for k := range out {
+		if isSensitive(k) {
+			out[k] = []string{redacted}
+		}
+	}
+	return out
+}
+
+func redactValues(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		if isSensitive(k) && v != "" {
+			v = redacted
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// redactBody masks the sensitive fields of a JSON object body, anything
+// that is not a JSON object is returned untouched.
+func redactBody(body []byte) string {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return string(body)
+	}
+	masked := false
+	for k := range fields {
+		if isSensitive(k) {
+			fields[k] = json.RawMessage(`"` + redacted + `"`)
+			masked = true
+		}
+	}
+	if !masked {
+		return string(body)
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
@@ -48,3 +48,16 @@ func GetEmail(token *jwt.Token) (email string, emailVerified, isPrivateEmail boo
 	ok = email != ""
 	return email, emailVerified, isPrivateEmail, ok
 }
+
+// GetTransferSub decodes the id_token and returns the transfer_sub claim,
+// which is present when the user was transferred from another team and their
+// identifier is not exchanged yet
+func GetTransferSub(token *jwt.Token) (transferSub string, ok bool) {
+	claims, _ := GetClaims(token)
+	if claims == nil {
+		return "", false
+	}
+
+	transferSub = cast.ToString(claims["transfer_sub"])
+	return transferSub, transferSub != ""
+}
@@ -0,0 +1,256 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyRingEventType is the kind of a KeyRingEvent.
+type KeyRingEventType string
+
+const (
+	KeyRingPrimaryRejected  KeyRingEventType = "primary_rejected"  // Apple rejected the client secret of the primary key.
+	KeyRingFallbackUsed     KeyRingEventType = "fallback_used"     // The request succeeded with the fallback key, after the primary key was rejected.
+	KeyRingFallbackRejected KeyRingEventType = "fallback_rejected" // Apple rejected the client secret of the fallback key too.
+	KeyRingRotated          KeyRingEventType = "rotated"           // A new primary key was set, the previous primary key is the fallback.
+)
+
+// KeyRingEvent is a key rollover event of a KeyRing.
+type KeyRingEvent struct {
+	Type  KeyRingEventType
+	KeyID string // The key the event is about, the new primary key of a rotation.
+	Err   error  // The error of Apple, for a rejection.
+}
+
+// KeyUsage is the usage of a key of a KeyRing. A fallback key that has not
+// succeeded for a while can be revoked in the Apple Developer Portal.
+type KeyUsage struct {
+	KeyID       string
+	Primary     bool      // Whether the key is the primary key.
+	Successes   int64     // The number of requests that succeeded with the key.
+	Rejections  int64     // The number of requests rejected with invalid_client.
+	LastSuccess time.Time // When a request last succeeded with the key.
+}
+
+// KeyRingConfig configures a KeyRing.
+type KeyRingConfig struct {
+	// Primary is the key used by default.
+	Primary AuthKey
+
+	// Fallback is the key used once when Apple rejects the primary key,
+	// such as the previous key during a rotation. Optional.
+	Fallback *AuthKey
+
+	// SecretLifetime is the lifetime of the client secrets generated. The
+	// zero value uses the default lifetime of GenerateClientSecretWithOptions.
+	SecretLifetime time.Duration
+
+	// OnEvent receives the key rollover events. Optional.
+	OnEvent func(event KeyRingEvent)
+}
+
+// KeyRing holds up to two keys of a team, as Apple allows two active Sign
+// in with Apple keys per team, so that a key can be rotated without
+// downtime. It generates the client secrets of the keys and renews them
+// before they expire. It is safe for concurrent use.
+type KeyRing struct {
+	lifetime time.Duration
+	onEvent  func(event KeyRingEvent)
+
+	mu       sync.Mutex
+	primary  *ringKey
+	fallback *ringKey
+}
+
+// ringKey is a key of a KeyRing with its current client secret
+type ringKey struct {
+	authKey   AuthKey
+	secret    Secret
+	expiresAt time.Time
+	usage     KeyUsage
+}
+
+// NewKeyRing creates a KeyRing. The keys must be valid, and belong to the
+// same team and client.
+func NewKeyRing(config KeyRingConfig) (*KeyRing, error) {
+	if config.SecretLifetime < 0 || config.SecretLifetime > MaxClientSecretLifetime {
+		return nil, fmt.Errorf("client secret lifetime %s must be positive and up to %s", config.SecretLifetime, MaxClientSecretLifetime)
+	}
+
+	ring := &KeyRing{lifetime: config.SecretLifetime, onEvent: config.OnEvent}
+	if err := ring.check(config.Primary); err != nil {
+		return nil, err
+	}
+	ring.primary = &ringKey{authKey: config.Primary}
+	if config.Fallback != nil {
+		if err := ring.check(*config.Fallback); err != nil {
+			return nil, err
+		}
+		ring.fallback = &ringKey{authKey: *config.Fallback}
+	}
+	return ring, nil
+}
+
+func (r *KeyRing) check(authKey AuthKey) error {
+	if err := authKey.Validate(); err != nil {
+		return fmt.Errorf("invalid auth key %q: %w", authKey.KeyID, err)
+	}
+	if r.primary == nil {
+		return nil
+	}
+	current := r.primary.authKey
+	switch {
+	case authKey.KeyID == current.KeyID:
+		return fmt.Errorf("key %q is already in the key ring", authKey.KeyID)
+	case authKey.TeamID != current.TeamID || authKey.ClientID != current.ClientID:
+		return fmt.Errorf("key %q belongs to team %q and client %q, not team %q and client %q",
+			authKey.KeyID, authKey.TeamID, authKey.ClientID, current.TeamID, current.ClientID)
+	}
+	return nil
+}
+
+// ClientID returns the client ID of the keys.
+func (r *KeyRing) ClientID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.primary.authKey.ClientID
+}
+
+// ClientSecret returns the client secret of the primary key.
+func (r *KeyRing) ClientSecret() (Secret, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.secret(r.primary)
+}
+
+// Rotate makes authKey the primary key, and the previous primary key the
+// fallback, replacing the previous fallback key.
+func (r *KeyRing) Rotate(authKey AuthKey) error {
+	r.mu.Lock()
+	if r.fallback != nil && r.fallback.authKey.KeyID == authKey.KeyID {
+		// promoting the fallback key
+		r.primary, r.fallback = r.fallback, r.primary
+	} else {
+		if err := r.check(authKey); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		r.primary, r.fallback = &ringKey{authKey: authKey}, r.primary
+	}
+	r.mu.Unlock()
+
+	r.emit(KeyRingEvent{Type: KeyRingRotated, KeyID: authKey.KeyID})
+	return nil
+}
+
+// Usage returns the usage of the keys, the primary key first.
+func (r *KeyRing) Usage() []KeyUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage := []KeyUsage{r.primary.usage}
+	usage[0].KeyID, usage[0].Primary = r.primary.authKey.KeyID, true
+	if r.fallback != nil {
+		fallback := r.fallback.usage
+		fallback.KeyID, fallback.Primary = r.fallback.authKey.KeyID, false
+		usage = append(usage, fallback)
+	}
+	return usage
+}
+
+// Do calls fn with the client secret of the primary key, and once again
+// with the client secret of the fallback key if Apple rejects the primary
+// key with invalid_client, such as:
+//
+//	err := ring.Do(ctx, func(clientSecret apple.Secret) (err error) {
+//		rsp, err = client.ValidateRefreshToken(ctx, ring.ClientID(), clientSecret, refreshToken)
+//		return err
+//	})
+//
+// Apple does not document whether an authorization code survives a request
+// rejected with invalid_client, so the fallback request of an
+// authorization_code grant may fail with invalid_grant.
+func (r *KeyRing) Do(ctx context.Context, fn func(clientSecret Secret) error) error {
+	r.mu.Lock()
+	primary, fallback := r.primary, r.fallback
+	secret, err := r.secret(primary)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = fn(secret)
+	r.record(primary, err)
+	if !isInvalidClient(err) {
+		return err
+	}
+	r.emit(KeyRingEvent{Type: KeyRingPrimaryRejected, KeyID: primary.authKey.KeyID, Err: err})
+	if fallback == nil || ctx.Err() != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	secret, err = r.secret(fallback)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = fn(secret)
+	r.record(fallback, err)
+	switch {
+	case err == nil:
+		r.emit(KeyRingEvent{Type: KeyRingFallbackUsed, KeyID: fallback.authKey.KeyID})
+	case isInvalidClient(err):
+		r.emit(KeyRingEvent{Type: KeyRingFallbackRejected, KeyID: fallback.authKey.KeyID, Err: err})
+	}
+	return err
+}
+
+// secret returns the client secret of key, generating a new one when it
+// has used three quarters of its lifetime, r.mu must be held
+func (r *KeyRing) secret(key *ringKey) (Secret, error) {
+	if key.secret != "" {
+		lifetime := r.lifetime
+		if lifetime == 0 {
+			lifetime = defaultClientSecretLifetime
+		}
+		if time.Until(key.expiresAt) > lifetime/4 {
+			return key.secret, nil
+		}
+	}
+
+	secret, expiresAt, err := GenerateClientSecretWithOptions(key.authKey, ClientSecretOptions{Lifetime: r.lifetime})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate client secret of key %q: %w", key.authKey.KeyID, err)
+	}
+	key.secret, key.expiresAt = secret, expiresAt
+	return secret, nil
+}
+
+// record counts the outcome of a request made with key
+func (r *KeyRing) record(key *ringKey, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err == nil:
+		key.usage.Successes++
+		key.usage.LastSuccess = time.Now()
+	case isInvalidClient(err):
+		key.usage.Rejections++
+	}
+}
+
+func (r *KeyRing) emit(event KeyRingEvent) {
+	if r.onEvent != nil {
+		r.onEvent(event)
+	}
+}
+
+// isInvalidClient reports whether Apple rejected the client secret
+func isInvalidClient(err error) bool {
+	return IsErrorCode(err, "invalid_client") || errors.Is(err, ErrInvalidClient)
+}
@@ -0,0 +1,124 @@
+package apple
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestKeyRingDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		fallback   bool
+		rejections map[string]string // the error of every key, by key ID
+		wantErr    string
+		wantKeys   []string
+		wantEvents []KeyRingEventType
+	}{
+		{
+			name:     "primary accepted",
+			fallback: true,
+			wantKeys: []string{"PRIMARY001"},
+		},
+		{
+			name:       "failover on invalid_client",
+			fallback:   true,
+			rejections: map[string]string{"PRIMARY001": "invalid_client"},
+			wantKeys:   []string{"PRIMARY001", "FALLBACK01"},
+			wantEvents: []KeyRingEventType{KeyRingPrimaryRejected, KeyRingFallbackUsed},
+		},
+		{
+			name:       "both rejected",
+			fallback:   true,
+			rejections: map[string]string{"PRIMARY001": "invalid_client", "FALLBACK01": "invalid_client"},
+			wantErr:    "invalid_client",
+			wantKeys:   []string{"PRIMARY001", "FALLBACK01"},
+			wantEvents: []KeyRingEventType{KeyRingPrimaryRejected, KeyRingFallbackRejected},
+		},
+		{
+			name:       "no failover on invalid_grant",
+			fallback:   true,
+			rejections: map[string]string{"PRIMARY001": "invalid_grant"},
+			wantErr:    "invalid_grant",
+			wantKeys:   []string{"PRIMARY001"},
+		},
+		{
+			name:       "no fallback key",
+			rejections: map[string]string{"PRIMARY001": "invalid_client"},
+			wantErr:    "invalid_client",
+			wantKeys:   []string{"PRIMARY001"},
+			wantEvents: []KeyRingEventType{KeyRingPrimaryRejected},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := testAuthKey(t)
+			primary.KeyID = "PRIMARY001"
+			config := KeyRingConfig{Primary: primary}
+			if tt.fallback {
+				fallback := testAuthKey(t)
+				fallback.KeyID = "FALLBACK01"
+				config.Fallback = &fallback
+			}
+			var events []KeyRingEventType
+			config.OnEvent = func(event KeyRingEvent) {
+				events = append(events, event.Type)
+			}
+			ring, err := NewKeyRing(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			err = ring.Do(context.Background(), func(clientSecret Secret) error {
+				info, err := ParseClientSecret(clientSecret, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys = append(keys, info.KeyID)
+				if code := tt.rejections[info.KeyID]; code != "" {
+					return &Error{StatusCode: http.StatusBadRequest, Code: code}
+				}
+				return nil
+			})
+
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && !IsErrorCode(err, tt.wantErr) {
+				t.Errorf("Do() error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys used = %v, want %v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestKeyRingDoCountsUsage(t *testing.T) {
+	primary, fallback := testAuthKey(t), testAuthKey(t)
+	primary.KeyID, fallback.KeyID = "PRIMARY001", "FALLBACK01"
+	ring, err := NewKeyRing(KeyRingConfig{Primary: primary, Fallback: &fallback})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a user migration error of the kind ErrInvalidClient fails over too
+	err = ring.Do(context.Background(), func(clientSecret Secret) error {
+		info, _ := ParseClientSecret(clientSecret, nil)
+		if info.KeyID == "PRIMARY001" {
+			return fmt.Errorf("failed to exchange: %w", ErrInvalidClient)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+
+	usage := ring.Usage()
+	if len(usage) != 2 || usage[0].Rejections != 1 || usage[0].Successes != 0 || usage[1].Successes != 1 || usage[1].LastSuccess.IsZero() {
+		t.Errorf("Usage() = %+v, want 1 rejection of the primary key and 1 success of the fallback key", usage)
+	}
+}
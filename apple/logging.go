@@ -0,0 +1,75 @@
+package apple
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// NewLoggingMiddleware returns a Middleware which logs every request to
+// Apple: successful ones at debug level, and failed ones at warn level. The
+// secrets and tokens in the request form are redacted. See WithLogger.
+func NewLoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			rsp, err := next.Do(ctx, req)
+
+			level := slog.LevelDebug
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("endpoint", string(req.Endpoint)),
+				slog.Any("form", redactValues(req.Form)),
+				slog.Int("status", statusCodeOf(rsp)),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if code := RequestErrorCode(rsp, err); code != "" {
+				level = slog.LevelWarn
+				attrs = append(attrs, slog.String("error_code", code))
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+
+			logger.LogAttrs(ctx, level, "sign in with apple request", attrs...)
+			return rsp, err
+		})
+	}
+}
+
+// RedactAttr redacts the value of any attribute named after a secret or a
+// token, such as "client_secret", "refresh_token" or "signing_key". Use it as
+// the ReplaceAttr of your slog.HandlerOptions, so that your own log lines are
+// safe too.
+func RedactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if isSensitive(attr.Key) && !attr.Value.Equal(slog.StringValue("")) {
+		return slog.String(attr.Key, redacted)
+	}
+	return attr
+}
+
+// LogValue implements slog.LogValuer, the signing key is never logged.
+func (k AuthKey) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("key_id", k.KeyID),
+		slog.String("client_id", k.ClientID),
+		slog.String("team_id", k.TeamID),
+		slog.Any("signing_key", k.SigningKey),
+	)
+}
+
+// LogValue implements slog.LogValuer, the tokens are never logged.
+func (r *TokenResponse) LogValue() slog.Value {
+	if r == nil {
+		return slog.AnyValue(nil)
+	}
+	return slog.GroupValue(
+		slog.Any("access_token", r.AccessToken),
+		slog.String("token_type", r.TokenType),
+		slog.Int("expires_in", r.ExpiresIn),
+		slog.Any("refresh_token", r.RefreshToken),
+		slog.String("id_token", r.IDToken.String()),
+		slog.String("error", r.Error),
+		slog.String("error_description", r.ErrorDescription),
+	)
+}
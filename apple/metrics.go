@@ -0,0 +1,197 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Metrics receives the measurements of the client. Implement it to adapt the
+// client to your own monitoring stack, or use NewExpvarMetrics.
+//
+// The methods are called synchronously, they should return quickly and must
+// be safe for concurrent use.
+type Metrics interface {
+	// RequestDone is called when a call to an endpoint is done, including all
+	// of its retries. The statusCode is 0 if no response was received, the
+	// errorCode is empty on success. See RequestErrorCode.
+	RequestDone(endpoint Endpoint, statusCode int, errorCode string, duration time.Duration)
+
+	// KeysRefreshed is called after every fetch of Apple's public keys, with
+	// the number of keys fetched and the error if the fetch failed.
+	KeysRefreshed(keyCount int, err error)
+
+	// TokenVerified is called after every verification of an id_token, with
+	// the age of the public keys used. The reason is empty if the token is
+	// verified, otherwise it is one of the VerifyFailure values.
+	TokenVerified(reason string, keysAge time.Duration)
+}
+
+// The reasons of an id_token verification failure, given to Metrics.
+const (
+	VerifyFailureEmptyToken       = "empty_token"
+	VerifyFailureMalformed        = "malformed"
+	VerifyFailureUnknownKey       = "unknown_key"
+	VerifyFailureInvalidSignature = "invalid_signature"
+	VerifyFailureExpired          = "expired"
+	VerifyFailureInvalidIssuer    = "invalid_issuer"
+	VerifyFailureInvalidAudience  = "invalid_audience"
+	VerifyFailureInvalidClaims    = "invalid_claims"
+)
+
+// RequestErrorCode returns the error code of a failed call to an endpoint:
+// Apple's error code such as "invalid_grant" when Apple answered with an
+// error, "http_<status>" when the error has no code, or a code of the
+// client-side failure, such as "timeout" or "circuit_open". It returns an
+// empty string if the call succeeded.
+func RequestErrorCode(rsp *Response, err error) string {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrTooManyInFlight):
+		return "too_many_in_flight"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case err != nil:
+		return "transport"
+	case rsp == nil:
+		return "no_response"
+	case rsp.isSuccess():
+		return ""
+	}
+
+	body := struct {
+		Error string `json:"error"`
+	}{}
+	if json.Unmarshal(rsp.Body, &body) == nil && body.Error != "" {
+		return body.Error
+	}
+	return "http_" + strconv.Itoa(rsp.StatusCode)
+}
+
+// NewMetricsMiddleware returns a Middleware which reports every call to
+// Metrics. See WithMetrics.
+func NewMetricsMiddleware(metrics Metrics) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			rsp, err := next.Do(ctx, req)
+			metrics.RequestDone(req.Endpoint, statusCodeOf(rsp), RequestErrorCode(rsp, err), time.Since(start))
+			return rsp, err
+		})
+	}
+}
+
+// verifyFailureReason maps the error of an id_token verification to one of
+// the VerifyFailure values
+func verifyFailureReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return VerifyFailureMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return VerifyFailureInvalidSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return VerifyFailureExpired
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return VerifyFailureInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return VerifyFailureInvalidAudience
+	default:
+		return VerifyFailureInvalidClaims
+	}
+}
+
+// NopMetrics is a Metrics that does nothing, it is the default of the client.
+type NopMetrics struct{}
+
+func (NopMetrics) RequestDone(Endpoint, int, string, time.Duration) {}
+func (NopMetrics) KeysRefreshed(int, error)                         {}
+func (NopMetrics) TokenVerified(string, time.Duration)              {}
+
+// ExpvarMetrics is a Metrics which publishes the measurements with the
+// standard expvar package, as a map of the following variables:
+//
+//	requests              calls per "<endpoint> <status code>"
+//	request_errors        failed calls per "<endpoint> <error code>"
+//	request_seconds       total duration of the calls per endpoint
+//	keys_refreshes        fetches of Apple's public keys
+//	keys_refresh_failures failed fetches of Apple's public keys
+//	keys_count            number of keys of the last successful fetch
+//	keys_age_seconds      age of the keys used by the last verification
+//	verifications         verifications per result, "ok" or the failure reason
+type ExpvarMetrics struct {
+	requests            *expvar.Map
+	requestErrors       *expvar.Map
+	requestSeconds      *expvar.Map
+	keysRefreshes       *expvar.Int
+	keysRefreshFailures *expvar.Int
+	keysCount           *expvar.Int
+	keysAgeSeconds      *expvar.Float
+	verifications       *expvar.Map
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics published under name. It panics
+// if name is already published by someone else than NewExpvarMetrics, just
+// like expvar.Publish does.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	root, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		root = expvar.NewMap(name)
+	}
+
+	m := &ExpvarMetrics{
+		requests:            new(expvar.Map).Init(),
+		requestErrors:       new(expvar.Map).Init(),
+		requestSeconds:      new(expvar.Map).Init(),
+		keysRefreshes:       new(expvar.Int),
+		keysRefreshFailures: new(expvar.Int),
+		keysCount:           new(expvar.Int),
+		keysAgeSeconds:      new(expvar.Float),
+		verifications:       new(expvar.Map).Init(),
+	}
+
+	root.Set("requests", m.requests)
+	root.Set("request_errors", m.requestErrors)
+	root.Set("request_seconds", m.requestSeconds)
+	root.Set("keys_refreshes", m.keysRefreshes)
+	root.Set("keys_refresh_failures", m.keysRefreshFailures)
+	root.Set("keys_count", m.keysCount)
+	root.Set("keys_age_seconds", m.keysAgeSeconds)
+	root.Set("verifications", m.verifications)
+
+	return m
+}
+
+func (m *ExpvarMetrics) RequestDone(endpoint Endpoint, statusCode int, errorCode string, duration time.Duration) {
+	m.requests.Add(string(endpoint)+" "+strconv.Itoa(statusCode), 1)
+	if errorCode != "" {
+		m.requestErrors.Add(string(endpoint)+" "+errorCode, 1)
+	}
+	m.requestSeconds.AddFloat(string(endpoint), duration.Seconds())
+}
+
+func (m *ExpvarMetrics) KeysRefreshed(keyCount int, err error) {
+	m.keysRefreshes.Add(1)
+	if err != nil || keyCount == 0 {
+		m.keysRefreshFailures.Add(1)
+		return
+	}
+	m.keysCount.Set(int64(keyCount))
+}
+
+func (m *ExpvarMetrics) TokenVerified(reason string, keysAge time.Duration) {
+	if reason == "" {
+		reason = "ok"
+	}
+	m.verifications.Add(reason, 1)
+	m.keysAgeSeconds.Set(keysAge.Seconds())
+}
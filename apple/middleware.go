@@ -0,0 +1,67 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+)
+
+// Request is a request to a Sign in with Apple REST endpoint, as seen by
+// middlewares.
+type Request struct {
+	Method   string            // The HTTP method.
+	Endpoint Endpoint          // The endpoint requested.
+	Header   http.Header       // The request headers, in addition to the client's default headers.
+	Form     map[string]string // The request form.
+}
+
+// Response is the response of a Sign in with Apple REST endpoint, as seen by
+// middlewares.
+type Response struct {
+	StatusCode int         // The HTTP status code.
+	Header     http.Header // The response headers.
+	Body       []byte      // The response body.
+}
+
+// Doer sends a Request to Apple and returns its Response. A Doer returns an
+// error only if no response was received, a response of any status code is
+// not an error.
+type Doer interface {
+	Do(ctx context.Context, req *Request) (*Response, error)
+}
+
+// DoerFunc is an adapter to use an ordinary function as a Doer.
+type DoerFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// Do calls f(ctx, req).
+func (f DoerFunc) Do(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
+
+// Middleware wraps a Doer with extra behavior, such as adding headers,
+// propagating traces or auditing the requests.
+type Middleware func(next Doer) Doer
+
+// Chain composes middlewares into a single Middleware, the first middleware
+// is the outermost one.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Doer) Doer {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			if middlewares[i] != nil {
+				next = middlewares[i](next)
+			}
+		}
+		return next
+	}
+}
+
+func (r *Response) isSuccess() bool {
+	return r != nil && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// statusCodeOf returns the status code of rsp, 0 if there is no response
+func statusCodeOf(rsp *Response) int {
+	if rsp == nil {
+		return 0
+	}
+	return rsp.StatusCode
+}
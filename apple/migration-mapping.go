@@ -0,0 +1,225 @@
+package apple
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MigrationMapping maps the identifiers of a user in the transferring team
+// to the identifiers in the recipient team, to rewrite the user identifiers
+// and private relay emails stored in your databases once a transfer is
+// complete.
+type MigrationMapping struct {
+	Sub            string         `json:"sub"`                        // The user identifier in the transferring team.
+	NewSub         string         `json:"new_sub,omitempty"`          // The user identifier in the recipient team, once exchanged.
+	Email          string         `json:"email,omitempty"`            // The email of the user in the transferring team, if known.
+	NewEmail       string         `json:"new_email,omitempty"`        // The private relay email in the recipient team, if any.
+	IsPrivateEmail bool           `json:"is_private_email,omitempty"` // Whether NewEmail is a private relay email.
+	Status         MigrationState `json:"status"`                     // The state of the user in the migration.
+}
+
+// migrationMappingHeader is the header of a mapping CSV file
+var migrationMappingHeader = []string{"sub", "new_sub", "email", "new_email", "is_private_email", "status"}
+
+// ExportMigrationMapping returns the mapping of every user in the store,
+// ordered by sub.
+func ExportMigrationMapping(ctx context.Context, store MigrationStore) ([]MigrationMapping, error) {
+	entries, err := store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migration entries: %w", err)
+	}
+
+	mappings := make([]MigrationMapping, 0, len(entries))
+	for _, entry := range entries {
+		mappings = append(mappings, MigrationMapping{
+			Sub:            entry.Sub,
+			NewSub:         entry.NewSub,
+			Email:          entry.Email,
+			NewEmail:       entry.NewEmail,
+			IsPrivateEmail: entry.IsPrivateEmail,
+			Status:         entry.State,
+		})
+	}
+	return mappings, nil
+}
+
+// WriteMigrationMappingCSV writes the mappings as CSV to w, with a header
+// line:
+//
+//	sub,new_sub,email,new_email,is_private_email,status
+func WriteMigrationMappingCSV(w io.Writer, mappings []MigrationMapping) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(migrationMappingHeader); err != nil {
+		return err
+	}
+	for _, m := range mappings {
+		record := []string{m.Sub, m.NewSub, m.Email, m.NewEmail, strconv.FormatBool(m.IsPrivateEmail), string(m.Status)}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadMigrationMappingCSV reads the mappings written by
+// WriteMigrationMappingCSV.
+func ReadMigrationMappingCSV(r io.Reader) ([]MigrationMapping, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(migrationMappingHeader)
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(header, ",") != strings.Join(migrationMappingHeader, ",") {
+		return nil, fmt.Errorf("invalid mapping header %q", strings.Join(header, ","))
+	}
+
+	var mappings []MigrationMapping
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return mappings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		isPrivateEmail, err := strconv.ParseBool(record[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid is_private_email at line %d: %w", line, err)
+		}
+		mappings = append(mappings, MigrationMapping{
+			Sub:            record[0],
+			NewSub:         record[1],
+			Email:          record[2],
+			NewEmail:       record[3],
+			IsPrivateEmail: isPrivateEmail,
+			Status:         MigrationState(record[5]),
+		})
+	}
+}
+
+// WriteMigrationMappingJSONL writes every mapping as a single JSON line to
+// w.
+func WriteMigrationMappingJSONL(w io.Writer, mappings []MigrationMapping) error {
+	encoder := json.NewEncoder(w)
+	for _, m := range mappings {
+		if err := encoder.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMigrationMappingJSONL reads the mappings written by
+// WriteMigrationMappingJSONL. Blank lines are skipped.
+func ReadMigrationMappingJSONL(r io.Reader) ([]MigrationMapping, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var mappings []MigrationMapping
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var m MigrationMapping
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("invalid mapping at line %d: %w", line, err)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, scanner.Err()
+}
+
+// MigrationReconciliation is the outcome of ReconcileMigration. Every list
+// holds user identifiers in the transferring team, in the order they are
+// found.
+type MigrationReconciliation struct {
+	Expected int // The number of distinct subs expected.
+	Complete int // The number of expected subs exchanged exactly once.
+
+	Missing       []string // The expected subs without a mapping.
+	Duplicate     []string // The subs with several mappings.
+	DuplicateNew  []string // The subs mapped to the same new sub as another sub.
+	Failed        []string // The expected subs whose migration failed.
+	Pending       []string // The expected subs whose migration is not complete.
+	Unexpected    []string // The subs mapped but not expected.
+	MissingNewSub []string // The subs exchanged without a new sub.
+}
+
+// OK reports whether every expected sub is exchanged exactly once, and
+// nothing else is mapped.
+func (r *MigrationReconciliation) OK() bool {
+	return r.Complete == r.Expected &&
+		len(r.Duplicate) == 0 && len(r.DuplicateNew) == 0 && len(r.Unexpected) == 0
+}
+
+// ReconcileMigration compares the mappings against the subs expected to be
+// migrated, such as the user identifiers stored in your databases, and
+// reports the subs missing, duplicated or failed.
+func ReconcileMigration(mappings []MigrationMapping, expected []string) *MigrationReconciliation {
+	r := &MigrationReconciliation{}
+
+	bySub := make(map[string][]MigrationMapping, len(mappings))
+	order := make([]string, 0, len(mappings))
+	newSubs := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		if _, ok := bySub[m.Sub]; !ok {
+			order = append(order, m.Sub)
+		} else if len(bySub[m.Sub]) == 1 {
+			r.Duplicate = append(r.Duplicate, m.Sub)
+		}
+		bySub[m.Sub] = append(bySub[m.Sub], m)
+
+		if m.NewSub == "" {
+			continue
+		}
+		if sub, ok := newSubs[m.NewSub]; ok && sub != m.Sub {
+			r.DuplicateNew = append(r.DuplicateNew, m.Sub)
+		} else if !ok {
+			newSubs[m.NewSub] = m.Sub
+		}
+	}
+
+	seen := make(map[string]bool, len(expected))
+	for _, sub := range expected {
+		if seen[sub] {
+			continue
+		}
+		seen[sub] = true
+		r.Expected++
+
+		found := bySub[sub]
+		switch {
+		case len(found) == 0:
+			r.Missing = append(r.Missing, sub)
+		case found[len(found)-1].Status == MigrationFailed:
+			r.Failed = append(r.Failed, sub)
+		case found[len(found)-1].Status != MigrationExchanged:
+			r.Pending = append(r.Pending, sub)
+		case found[len(found)-1].NewSub == "":
+			r.MissingNewSub = append(r.MissingNewSub, sub)
+		case len(found) == 1:
+			r.Complete++
+		}
+	}
+
+	for _, sub := range order {
+		if !seen[sub] {
+			r.Unexpected = append(r.Unexpected, sub)
+		}
+	}
+	return r
+}
@@ -0,0 +1,144 @@
+package apple
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrationMappingRoundTrip(t *testing.T) {
+	store := NewMemoryMigrationStore()
+	for _, entry := range []MigrationEntry{
+		{Sub: "u2", State: MigrationFailed, Email: "b@example.com", Error: "invalid subject"},
+		{Sub: "u1", State: MigrationExchanged, NewSub: "n1", Email: "a@example.com", NewEmail: "x@privaterelay.appleid.com", IsPrivateEmail: true},
+		{Sub: "u3", State: MigrationTransferSubGenerated, TransferSub: "t.u3", Email: `"quoted", with comma`},
+	} {
+		if err := store.Save(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mappings, err := ExportMigrationMapping(context.Background(), store)
+	if err != nil {
+		t.Fatalf("ExportMigrationMapping() error = %v", err)
+	}
+	want := []MigrationMapping{
+		{Sub: "u1", NewSub: "n1", Email: "a@example.com", NewEmail: "x@privaterelay.appleid.com", IsPrivateEmail: true, Status: MigrationExchanged},
+		{Sub: "u2", Email: "b@example.com", Status: MigrationFailed},
+		{Sub: "u3", Email: `"quoted", with comma`, Status: MigrationTransferSubGenerated},
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Fatalf("ExportMigrationMapping() = %+v, want %+v", mappings, want)
+	}
+
+	formats := []struct {
+		name  string
+		write func(io.Writer, []MigrationMapping) error
+		read  func(io.Reader) ([]MigrationMapping, error)
+	}{
+		{"CSV", WriteMigrationMappingCSV, ReadMigrationMappingCSV},
+		{"JSONL", WriteMigrationMappingJSONL, ReadMigrationMappingJSONL},
+	}
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := format.write(&buf, mappings); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			got, err := format.read(&buf)
+			if err != nil {
+				t.Fatalf("read error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("read = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadMigrationMappingCSVErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"other header", "sub,new_sub\nu1,n1\n", "wrong number of fields"},
+		{"renamed column", "sub,new_sub,email,new_email,private,status\n", "invalid mapping header"},
+		{"invalid bool", "sub,new_sub,email,new_email,is_private_email,status\nu1,n1,,,maybe,exchanged\n", "invalid is_private_email at line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadMigrationMappingCSV(strings.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadMigrationMappingCSV() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReconcileMigration(t *testing.T) {
+	exchanged := func(sub, newSub string) MigrationMapping {
+		return MigrationMapping{Sub: sub, NewSub: newSub, Status: MigrationExchanged}
+	}
+
+	tests := []struct {
+		name     string
+		mappings []MigrationMapping
+		expected []string
+		want     MigrationReconciliation
+	}{
+		{
+			name:     "complete",
+			mappings: []MigrationMapping{exchanged("u1", "n1"), exchanged("u2", "n2")},
+			expected: []string{"u1", "u2", "u1"},
+			want:     MigrationReconciliation{Expected: 2, Complete: 2},
+		},
+		{
+			name:     "missing",
+			mappings: []MigrationMapping{exchanged("u1", "n1")},
+			expected: []string{"u1", "u2"},
+			want:     MigrationReconciliation{Expected: 2, Complete: 1, Missing: []string{"u2"}},
+		},
+		{
+			name:     "extra",
+			mappings: []MigrationMapping{exchanged("u1", "n1"), exchanged("u9", "n9")},
+			expected: []string{"u1"},
+			want:     MigrationReconciliation{Expected: 1, Complete: 1, Unexpected: []string{"u9"}},
+		},
+		{
+			name:     "duplicate sub",
+			mappings: []MigrationMapping{exchanged("u1", "n1"), exchanged("u1", "n1"), exchanged("u1", "n1")},
+			expected: []string{"u1"},
+			want:     MigrationReconciliation{Expected: 1, Duplicate: []string{"u1"}},
+		},
+		{
+			name:     "mismatched new sub",
+			mappings: []MigrationMapping{exchanged("u1", "n1"), exchanged("u2", "n1")},
+			expected: []string{"u1", "u2"},
+			want:     MigrationReconciliation{Expected: 2, Complete: 2, DuplicateNew: []string{"u2"}},
+		},
+		{
+			name: "incomplete",
+			mappings: []MigrationMapping{
+				{Sub: "u1", Status: MigrationFailed},
+				{Sub: "u2", Status: MigrationTransferSubGenerated},
+				{Sub: "u3", Status: MigrationExchanged},
+			},
+			expected: []string{"u1", "u2", "u3"},
+			want:     MigrationReconciliation{Expected: 3, Failed: []string{"u1"}, Pending: []string{"u2"}, MissingNewSub: []string{"u3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ReconcileMigration(tt.mappings, tt.expected)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ReconcileMigration() = %+v, want %+v", *got, tt.want)
+			}
+			if ok := tt.name == "complete"; got.OK() != ok {
+				t.Errorf("OK() = %t, want %t", got.OK(), ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package apple
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MigrationState is the state of a user in a migration.
+type MigrationState string
+
+const (
+	MigrationPending              MigrationState = "pending"                // Nothing is done yet.
+	MigrationTransferSubGenerated MigrationState = "transfer_sub_generated" // The transfer identifier is generated.
+	MigrationExchanged            MigrationState = "exchanged"              // The transfer identifier is exchanged for the new sub.
+	MigrationFailed               MigrationState = "failed"                 // The last step failed, see Error.
+)
+
+// MigrationEntry is the state of a user in a migration.
+type MigrationEntry struct {
+	Sub            string         `json:"sub"`                        // The user identifier in the transferring team.
+	Email          string         `json:"email,omitempty"`            // The email of the user in the transferring team, if known.
+	State          MigrationState `json:"state"`                      // The state of the user.
+	TransferSub    string         `json:"transfer_sub,omitempty"`     // The transfer identifier, once generated.
+	NewSub         string         `json:"new_sub,omitempty"`          // The user identifier in the recipient team, once exchanged.
+	NewEmail       string         `json:"new_email,omitempty"`        // The private relay email in the recipient team, if any.
+	IsPrivateEmail bool           `json:"is_private_email,omitempty"` // Whether NewEmail is a private relay email.
+	Attempts       int            `json:"attempts,omitempty"`         // The number of requests sent for the user.
+	ErrorCode      string         `json:"error_code,omitempty"`       // Apple's error code of the last failure, or the client-side failure.
+	Error          string         `json:"error,omitempty"`            // The last failure.
+	UpdatedAt      time.Time      `json:"updated_at"`                 // When the entry was last saved.
+}
+
+// MigrationStore persists the state of every user in a migration, so that a
+// migration run again skips the work already done. Implementations must be
+// safe for concurrent use.
+type MigrationStore interface {
+	// Load returns the entry of sub, or nil if there is none.
+	Load(ctx context.Context, sub string) (*MigrationEntry, error)
+	// Save saves the entry, replacing the previous entry of the same sub.
+	Save(ctx context.Context, entry MigrationEntry) error
+	// List returns all the entries, ordered by sub.
+	List(ctx context.Context) ([]MigrationEntry, error)
+}
+
+// MemoryMigrationStore is a MigrationStore holding the entries in memory.
+type MemoryMigrationStore struct {
+	mu      sync.RWMutex
+	entries map[string]MigrationEntry
+}
+
+// NewMemoryMigrationStore creates an empty MemoryMigrationStore.
+func NewMemoryMigrationStore() *MemoryMigrationStore {
+	return &MemoryMigrationStore{entries: make(map[string]MigrationEntry)}
+}
+
+func (s *MemoryMigrationStore) Load(_ context.Context, sub string) (*MigrationEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[sub]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *MemoryMigrationStore) Save(_ context.Context, entry MigrationEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.Sub] = entry
+	return nil
+}
+
+func (s *MemoryMigrationStore) List(_ context.Context) ([]MigrationEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]MigrationEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sub < entries[j].Sub })
+	return entries, nil
+}
+
+// FileMigrationStore is a MigrationStore appending every saved entry as a
+// JSON line to a file, the last line of a sub wins. The file is read back
+// into memory when opened, so that an interrupted migration resumes.
+type FileMigrationStore struct {
+	*MemoryMigrationStore
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileMigrationStore opens the migration store at path, creating it if
+// needed. A last line partially written by a crash is cut off, its entry is
+// redone by the next run.
+func OpenFileMigrationStore(path string) (*FileMigrationStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileMigrationStore{MemoryMigrationStore: NewMemoryMigrationStore(), file: file}
+	if err = store.load(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot open migration store %q: %w", path, err)
+	}
+	return store, nil
+}
+
+// load reads the entries of the file, and cuts off a last line without
+// newline, the partial write of a crash, unless it is a valid entry
+func (s *FileMigrationStore) load() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		torn := errors.Is(err, io.EOF)
+		if len(bytes.TrimSpace(data)) == 0 {
+			if torn {
+				return nil
+			}
+			offset += int64(len(data))
+			continue
+		}
+
+		var entry MigrationEntry
+		if err = json.Unmarshal(data, &entry); err != nil {
+			if torn {
+				return s.file.Truncate(offset)
+			}
+			return fmt.Errorf("invalid migration entry at line %d: %w", line, err)
+		}
+		s.entries[entry.Sub] = entry
+		if torn {
+			_, err = s.file.Write([]byte{'\n'})
+			return err
+		}
+		offset += int64(len(data))
+	}
+}
+
+func (s *FileMigrationStore) Save(ctx context.Context, entry MigrationEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		return err
+	}
+	return s.MemoryMigrationStore.Save(ctx, entry)
+}
+
+// Close closes the migration store.
+func (s *FileMigrationStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
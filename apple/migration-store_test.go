@@ -0,0 +1,98 @@
+package apple
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenFileMigrationStoreTornLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantSubs []string
+		wantFile string
+	}{
+		{
+			name:     "torn last line is cut off",
+			content:  `{"sub":"a","state":"pending"}` + "\n" + `{"sub":"b","sta`,
+			wantSubs: []string{"a"},
+			wantFile: `{"sub":"a","state":"pending"}` + "\n",
+		},
+		{
+			name:     "valid last line without newline is kept",
+			content:  `{"sub":"a","state":"pending"}` + "\n" + `{"sub":"b","state":"pending"}`,
+			wantSubs: []string{"a", "b"},
+			wantFile: `{"sub":"a","state":"pending"}` + "\n" + `{"sub":"b","state":"pending"}` + "\n",
+		},
+		{
+			name:     "blank lines are skipped",
+			content:  "\n" + `{"sub":"a","state":"pending"}` + "\n\n",
+			wantSubs: []string{"a"},
+			wantFile: "\n" + `{"sub":"a","state":"pending"}` + "\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "migration.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := OpenFileMigrationStore(path)
+			if err != nil {
+				t.Fatalf("OpenFileMigrationStore() error = %v", err)
+			}
+			defer func() { _ = store.Close() }()
+
+			entries, _ := store.List(context.Background())
+			var subs []string
+			for _, entry := range entries {
+				subs = append(subs, entry.Sub)
+			}
+			if strings.Join(subs, ",") != strings.Join(tt.wantSubs, ",") {
+				t.Errorf("subs = %v, want %v", subs, tt.wantSubs)
+			}
+			if data, _ := os.ReadFile(path); string(data) != tt.wantFile {
+				t.Errorf("file = %q, want %q", data, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestOpenFileMigrationStoreInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migration.jsonl")
+	content := `{"sub":"a","sta` + "\n" + `{"sub":"b","state":"pending"}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileMigrationStore(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("OpenFileMigrationStore() error = %v, want an error at line 1", err)
+	}
+}
+
+func TestFileMigrationStoreAppendAfterTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migration.jsonl")
+	if err := os.WriteFile(path, []byte(`{"sub":"a","state":"pending"}`+"\n"+`{"sub":"b"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileMigrationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(context.Background(), MigrationEntry{Sub: "b", State: MigrationPending}); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	if store, err = OpenFileMigrationStore(path); err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if entry, _ := store.Load(context.Background(), "b"); entry == nil {
+		t.Error("entry of b is missing after reopen")
+	}
+}
@@ -0,0 +1,105 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TransferringTeam is the team an app and its users are transferred from.
+// It generates the transfer identifiers with its own client secret and
+// migration access token, which never leave it.
+//
+// Ref: https://developer.apple.com/documentation/sign_in_with_apple/transferring-your-apps-and-users-to-another-team
+type TransferringTeam struct {
+	team
+}
+
+// NewTransferringTeam creates the TransferringTeam of authKey, the key of
+// the team transferring the app.
+func NewTransferringTeam(client Client, authKey AuthKey) (*TransferringTeam, error) {
+	t, err := newTeam(client, authKey)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferringTeam{team: *t}, nil
+}
+
+// GenerateTransferSub generates the transfer identifier of the user sub for
+// the recipient team, see Client.GenerateTransferSub.
+func (t *TransferringTeam) GenerateTransferSub(ctx context.Context, recipientTeamID, sub string) (transferSub string, err error) {
+	err = t.tokens.Do(ctx, func(accessToken Secret) error {
+		transferSub, err = t.client.GenerateTransferSub(ctx, t.clientID, recipientTeamID, t.clientSecret, accessToken, sub)
+		return err
+	})
+	return transferSub, err
+}
+
+// RecipientTeam is the team an app and its users are transferred to. It
+// exchanges the transfer identifiers with its own client secret and
+// migration access token, which never leave it.
+//
+// Ref: https://developer.apple.com/documentation/sign_in_with_apple/bringing-new-apps-and-users-into-your-team
+type RecipientTeam struct {
+	team
+}
+
+// NewRecipientTeam creates the RecipientTeam of authKey, the key of the
+// team receiving the app.
+func NewRecipientTeam(client Client, authKey AuthKey) (*RecipientTeam, error) {
+	t, err := newTeam(client, authKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RecipientTeam{team: *t}, nil
+}
+
+// ExchangeIdentifier exchanges a transfer identifier generated for this
+// team for the user identifier in this team, see Client.ExchangeIdentifier.
+func (t *RecipientTeam) ExchangeIdentifier(ctx context.Context, transferSub string) (rsp *ExchangeIdentifierResponse, err error) {
+	err = t.tokens.Do(ctx, func(accessToken Secret) error {
+		rsp, err = t.client.ExchangeIdentifier(ctx, t.clientID, t.clientSecret, accessToken, transferSub)
+		return err
+	})
+	return rsp, err
+}
+
+// team holds the credentials of a team taking part in a migration
+type team struct {
+	client       Client
+	clientID     string
+	teamID       string
+	clientSecret Secret
+	tokens       *MigrationTokenSource
+}
+
+func newTeam(client Client, authKey AuthKey) (*team, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if err := authKey.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth key of team %q: %w", authKey.TeamID, err)
+	}
+	clientSecret, err := GenerateClientSecret(authKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client secret of team %q: %w", authKey.TeamID, err)
+	}
+	return &team{
+		client:       client,
+		clientID:     authKey.ClientID,
+		teamID:       authKey.TeamID,
+		clientSecret: clientSecret,
+		tokens:       NewMigrationTokenSource(client, authKey.ClientID, clientSecret),
+	}, nil
+}
+
+// ClientID returns the identifier (App ID or Services ID) of the app in the
+// team.
+func (t *team) ClientID() string {
+	return t.clientID
+}
+
+// TeamID returns the Team ID of the team.
+func (t *team) TeamID() string {
+	return t.teamID
+}
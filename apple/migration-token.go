@@ -0,0 +1,94 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MigrationTokenSource caches the migration access token of a team, which
+// Apple issues for about an hour, and obtains a new one a minute before it
+// expires or when Apple rejects it. It is safe for concurrent use, the
+// workers waiting for a new access token share the same request.
+type MigrationTokenSource struct {
+	client       Client
+	clientID     string
+	clientSecret Secret
+
+	mu        sync.Mutex
+	current   Secret
+	expiresAt time.Time
+}
+
+// NewMigrationTokenSource creates a MigrationTokenSource obtaining the
+// migration access tokens of clientID, see
+// Client.ObtainMigrationAccessToken.
+func NewMigrationTokenSource(client Client, clientID string, clientSecret Secret) *MigrationTokenSource {
+	return &MigrationTokenSource{client: client, clientID: clientID, clientSecret: clientSecret}
+}
+
+// Token returns the current migration access token, obtaining a new one if
+// there is none or it is about to expire.
+func (s *MigrationTokenSource) Token(ctx context.Context) (Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// renew a minute early, so that a token is not used as it expires
+	if s.current != "" && time.Now().Before(s.expiresAt.Add(-time.Minute)) {
+		return s.current, nil
+	}
+
+	rsp, err := s.client.ObtainMigrationAccessToken(ctx, s.clientID, s.clientSecret)
+	if err != nil {
+		return "", err
+	}
+	if rsp.AccessToken == "" {
+		return "", errors.New("no access_token in Apple's response")
+	}
+	s.current = rsp.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(rsp.ExpiresIn) * time.Second)
+	return s.current, nil
+}
+
+// Invalidate forgets the access token, if it is still the current one, so
+// that the next call to Token obtains a new one.
+func (s *MigrationTokenSource) Invalidate(token Secret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == token {
+		s.current = ""
+	}
+}
+
+// Do calls fn with the current migration access token, and once again with
+// a new access token if fn returns an error wrapping ErrInvalidAccessToken or
+// an Error of a 401 Unauthorized response, such as:
+//
+//	err := source.Do(ctx, func(accessToken apple.Secret) (err error) {
+//		transferSub, err = client.GenerateTransferSub(ctx, clientID, recipientTeamID, clientSecret, accessToken, sub)
+//		return err
+//	})
+func (s *MigrationTokenSource) Do(ctx context.Context, fn func(accessToken Secret) error) error {
+	for renewed := false; ; renewed = true {
+		accessToken, err := s.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to obtain migration access token: %w", err)
+		}
+		err = fn(accessToken)
+		if !isAccessTokenExpired(err) || renewed {
+			return err
+		}
+		s.Invalidate(accessToken)
+	}
+}
+
+// isAccessTokenExpired reports whether err may be caused by an expired
+// migration access token: any 401 Unauthorized response is, whatever its
+// error code
+func isAccessTokenExpired(err error) bool {
+	var e *Error
+	return errors.Is(err, ErrInvalidAccessToken) || errors.As(err, &e) && e.StatusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,49 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestMigrationTokenSourceRenewsOnUnauthorized(t *testing.T) {
+	tests := []struct {
+		name        string
+		rejection   *Response
+		wantTokens  int
+		wantRetried bool
+	}{
+		{"invalid_token", &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"invalid_token"}`)}, 2, true},
+		{"401 invalid_client", &Response{StatusCode: http.StatusUnauthorized, Body: []byte(`{"error":"invalid_client"}`)}, 2, true},
+		{"bare 401", &Response{StatusCode: http.StatusUnauthorized}, 2, true},
+		{"invalid_request", &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"invalid_request"}`)}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, generations := 0, 0
+			c := newStubClient(t, func(req *Request) *Response {
+				if req.Endpoint == EndpointToken {
+					tokens++
+					return &Response{StatusCode: http.StatusOK, Body: []byte(`{"access_token":"at","expires_in":3600}`)}
+				}
+				generations++
+				if generations == 1 {
+					return tt.rejection
+				}
+				return &Response{StatusCode: http.StatusOK, Body: []byte(`{"transfer_sub":"t.u1"}`)}
+			})
+
+			source := NewMigrationTokenSource(c, "com.example.app", "secret")
+			err := source.Do(context.Background(), func(accessToken Secret) error {
+				_, err := c.GenerateTransferSub(context.Background(), "com.example.app", "RECIPIENT1", "secret", accessToken, "u1")
+				return err
+			})
+			if (err == nil) != tt.wantRetried {
+				t.Errorf("Do() error = %v, want retried %t", err, tt.wantRetried)
+			}
+			if tokens != tt.wantTokens {
+				t.Errorf("access tokens obtained = %d, want %d", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MigrationUser is a user to migrate.
+type MigrationUser struct {
+	Sub   string // The user identifier in the transferring team.
+	Email string // The email of the user in the transferring team, optional.
+}
+
+// MigrationConfig configures Migrate.
+type MigrationConfig struct {
+	// Transferring is the transferring team, which generates the transfer
+	// identifiers. Leave it nil to only exchange the transfer identifiers
+	// already in the store.
+	Transferring *TransferringTeam
+
+	// RecipientTeamID is the Team ID of the recipient team, required to
+	// generate the transfer identifiers. Defaults to the Team ID of
+	// Recipient.
+	RecipientTeamID string
+
+	// Recipient is the recipient team, which exchanges the transfer
+	// identifiers. Leave it nil to only generate the transfer identifiers,
+	// when the recipient team runs its own migration.
+	Recipient *RecipientTeam
+
+	// Store persists the state of every user. Required.
+	Store MigrationStore
+
+	// Workers is the number of users migrated at the same time, each of them
+	// going through its steps in turn. Defaults to 4.
+	Workers int
+
+	// RateLimit limits the transfer identifier generations and exchanges of
+	// the run, both teams and all workers together. Leave it zero when the
+	// clients of the teams are rate limited already.
+	RateLimit RateLimit
+
+	// Retry is how a step of a user failing temporarily, such as an exchange
+	// answered with a server error, is retried before the user is saved as
+	// failed. Leave MaxAttempts zero for DefaultRetryPolicy, or set it to 1
+	// when the clients of the teams retry already.
+	Retry RetryPolicy
+
+	// SkipFailed skips the users who failed in a previous run, instead of
+	// trying them again.
+	SkipFailed bool
+}
+
+// MigrationSummary is the outcome of Migrate.
+type MigrationSummary struct {
+	Skipped   int64         // The number of users already done, or failed when SkipFailed is set.
+	Generated int64         // The number of transfer identifiers generated.
+	Exchanged int64         // The number of transfer identifiers exchanged.
+	Failed    int64         // The number of users who failed.
+	Duration  time.Duration // How long the run took.
+}
+
+// Migrate transfers users from the transferring team to the recipient team
+// with a pool of workers: it generates the transfer identifier of every
+// user, then exchanges it for the user identifier in the recipient team.
+//
+// The state of every user is saved to the store after every step, and the
+// steps already done are skipped, so running Migrate again after an
+// interruption resumes the migration. The migration access token of each
+// team is obtained when needed, and renewed when it expires.
+//
+// Migrate stops at the first error of the store, or when ctx is done, and
+// returns the summary so far along with the error. A user who fails is
+// saved as MigrationFailed and does not stop the migration.
+//
+// Ref: https://developer.apple.com/documentation/sign_in_with_apple/transferring-your-apps-and-users-to-another-team
+func Migrate(ctx context.Context, config MigrationConfig, users []MigrationUser) (*MigrationSummary, error) {
+	start := time.Now()
+
+	if config.Store == nil {
+		return nil, errors.New("migration store is required")
+	}
+	if config.Transferring == nil && config.Recipient == nil {
+		return nil, errors.New("transferring or recipient team is required")
+	}
+	if config.RecipientTeamID == "" && config.Recipient != nil {
+		config.RecipientTeamID = config.Recipient.TeamID()
+	}
+	if config.Transferring != nil && config.RecipientTeamID == "" {
+		return nil, errors.New("recipient team ID is required to generate transfer identifiers")
+	}
+
+	m := &migration{
+		config: config,
+		policy: jobRetryPolicy(config.Retry),
+	}
+	if !config.RateLimit.unlimited() {
+		m.limit = newLimiter(RateLimitPolicy{
+			Endpoints: map[Endpoint]RateLimit{EndpointMigration: config.RateLimit},
+			Wait:      true,
+		})
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan MigrationUser)
+	go func() {
+		defer close(jobs)
+		for _, user := range users {
+			select {
+			case jobs <- user:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				if err := m.migrate(ctx, user); err != nil {
+					m.fail(err)
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.summary.Duration = time.Since(start)
+	if m.err != nil {
+		return &m.summary, m.err
+	}
+	return &m.summary, ctx.Err()
+}
+
+// migration is a run of Migrate
+type migration struct {
+	config MigrationConfig
+	policy RetryPolicy
+	limit  *limiter
+
+	mu      sync.Mutex
+	summary MigrationSummary
+	err     error
+}
+
+func (m *migration) count(counter *int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*counter++
+}
+
+func (m *migration) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+// migrate moves a user forward as far as the configuration allows, only
+// the errors of the store are returned
+func (m *migration) migrate(ctx context.Context, user MigrationUser) error {
+	entry, err := m.config.Store.Load(ctx, user.Sub)
+	if err != nil {
+		return fmt.Errorf("failed to load migration entry of %q: %w", user.Sub, err)
+	}
+	if entry == nil {
+		entry = &MigrationEntry{Sub: user.Sub, State: MigrationPending}
+	}
+	if entry.Email == "" {
+		entry.Email = user.Email
+	}
+
+	switch {
+	case entry.State == MigrationExchanged,
+		entry.TransferSub != "" && m.config.Recipient == nil,
+		entry.State == MigrationFailed && m.config.SkipFailed,
+		entry.TransferSub == "" && m.config.Transferring == nil:
+		m.count(&m.summary.Skipped)
+		return nil
+	}
+
+	if entry.TransferSub == "" {
+		err = m.do(ctx, entry, func() error {
+			transferSub, err := m.config.Transferring.GenerateTransferSub(ctx, m.config.RecipientTeamID, entry.Sub)
+			entry.TransferSub = transferSub
+			return err
+		})
+		if err != nil {
+			return m.save(ctx, entry, err)
+		}
+		entry.State = MigrationTransferSubGenerated
+		m.count(&m.summary.Generated)
+		if err = m.save(ctx, entry, nil); err != nil || m.config.Recipient == nil {
+			return err
+		}
+	}
+
+	err = m.do(ctx, entry, func() error {
+		rsp, err := m.config.Recipient.ExchangeIdentifier(ctx, entry.TransferSub)
+		if err == nil {
+			entry.NewSub = rsp.Sub
+			entry.NewEmail = rsp.Email
+			entry.IsPrivateEmail = rsp.IsPrivateEmail
+		}
+		return err
+	})
+	if err != nil {
+		return m.save(ctx, entry, err)
+	}
+	entry.State = MigrationExchanged
+	m.count(&m.summary.Exchanged)
+	return m.save(ctx, entry, nil)
+}
+
+// do runs a step of the migration, and retries it on temporary failures
+func (m *migration) do(ctx context.Context, entry *MigrationEntry, step func() error) error {
+	return retryJob(ctx, m.policy, func() error {
+		if err := m.limit.wait(ctx, EndpointMigration, ""); err != nil {
+			return err
+		}
+		entry.Attempts++
+		return step()
+	})
+}
+
+// save saves the entry, as failed if err is not nil
+func (m *migration) save(ctx context.Context, entry *MigrationEntry, err error) error {
+	entry.Error, entry.ErrorCode = "", ""
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil // interrupted, the entry stays as it was
+		}
+		entry.State = MigrationFailed
+		entry.Error = err.Error()
+		entry.ErrorCode = revocationErrorCode(err)
+		m.count(&m.summary.Failed)
+	}
+	entry.UpdatedAt = time.Now().UTC()
+
+	if err = m.config.Store.Save(ctx, *entry); err != nil {
+		return fmt.Errorf("failed to save migration entry of %q: %w", entry.Sub, err)
+	}
+	return nil
+}
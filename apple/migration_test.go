@@ -0,0 +1,187 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// migrationStub answers the user migration requests of both teams in place
+// of Apple, generate and exchange are called with the sub and the
+// transfer_sub of the request
+type migrationStub struct {
+	generate func(sub string) *Response
+	exchange func(transferSub string) *Response
+
+	mu       sync.Mutex
+	requests map[string]int // the requests of every sub and transfer_sub
+}
+
+func (s *migrationStub) respond(req *Request) *Response {
+	if req.Endpoint == EndpointToken {
+		return &Response{StatusCode: http.StatusOK, Body: []byte(`{"access_token":"at","expires_in":3600}`)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := req.Form["sub"]; ok {
+		s.requests[sub]++
+		return s.generate(sub)
+	}
+	transferSub := req.Form["transfer_sub"]
+	s.requests[transferSub]++
+	return s.exchange(transferSub)
+}
+
+// newMigrationTeams creates the teams of a migration sending their requests
+// to stub
+func newMigrationTeams(t *testing.T, stub *migrationStub) (*TransferringTeam, *RecipientTeam) {
+	t.Helper()
+	stub.requests = make(map[string]int)
+	c := newStubClient(t, stub.respond)
+
+	transferring, err := NewTransferringTeam(c, testAuthKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey := testAuthKey(t)
+	recipientKey.TeamID = "RECIPIENT1"
+	recipient, err := NewRecipientTeam(c, recipientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transferring, recipient
+}
+
+// generated answers a transfer identifier derived from the sub
+func generated(sub string) *Response {
+	return &Response{StatusCode: http.StatusOK, Body: []byte(`{"transfer_sub":"t.` + sub + `"}`)}
+}
+
+// exchanged answers a user identifier derived from the transfer_sub
+func exchanged(transferSub string) *Response {
+	return &Response{StatusCode: http.StatusOK, Body: []byte(`{"sub":"new.` + transferSub + `"}`)}
+}
+
+func TestMigrateResumes(t *testing.T) {
+	stub := &migrationStub{generate: generated, exchange: exchanged}
+	transferring, recipient := newMigrationTeams(t, stub)
+
+	store := NewMemoryMigrationStore()
+	_ = store.Save(context.Background(), MigrationEntry{Sub: "u1", State: MigrationExchanged, TransferSub: "t.u1", NewSub: "new.t.u1"})
+	_ = store.Save(context.Background(), MigrationEntry{Sub: "u2", State: MigrationTransferSubGenerated, TransferSub: "t.u2"})
+	users := []MigrationUser{{Sub: "u1"}, {Sub: "u2"}, {Sub: "u3"}}
+
+	summary, err := Migrate(context.Background(), MigrationConfig{
+		Transferring: transferring,
+		Recipient:    recipient,
+		Store:        store,
+	}, users)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if summary.Skipped != 1 || summary.Generated != 1 || summary.Exchanged != 2 || summary.Failed != 0 {
+		t.Errorf("summary = %+v, want 1 skipped, 1 generated and 2 exchanged", summary)
+	}
+	for sub, want := range map[string]int{"u1": 0, "t.u1": 0, "u2": 0, "t.u2": 1, "u3": 1, "t.u3": 1} {
+		if got := stub.requests[sub]; got != want {
+			t.Errorf("requests of %s = %d, want %d", sub, got, want)
+		}
+	}
+	for _, user := range users {
+		entry, _ := store.Load(context.Background(), user.Sub)
+		if entry.State != MigrationExchanged || entry.NewSub != "new.t."+user.Sub {
+			t.Errorf("entry = %+v, want exchanged for new.t.%s", entry, user.Sub)
+		}
+	}
+
+	// everything is done, a run again sends no request
+	summary, err = Migrate(context.Background(), MigrationConfig{Transferring: transferring, Recipient: recipient, Store: store}, users)
+	if err != nil || summary.Skipped != 3 {
+		t.Errorf("Migrate() again = %+v, %v, want 3 skipped", summary, err)
+	}
+}
+
+func TestMigrateIsolatesFailures(t *testing.T) {
+	failing := true
+	stub := &migrationStub{
+		generate: func(sub string) *Response {
+			if sub == "u2" {
+				return &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"invalid_request","error_description":"Invalid sub"}`)}
+			}
+			return generated(sub)
+		},
+		exchange: func(transferSub string) *Response {
+			if transferSub == "t.u3" && failing {
+				failing = false
+				return &Response{StatusCode: http.StatusServiceUnavailable}
+			}
+			return exchanged(transferSub)
+		},
+	}
+	transferring, recipient := newMigrationTeams(t, stub)
+	store := NewMemoryMigrationStore()
+	users := []MigrationUser{{Sub: "u1"}, {Sub: "u2"}, {Sub: "u3"}}
+	config := MigrationConfig{
+		Transferring: transferring,
+		Recipient:    recipient,
+		Store:        store,
+		Retry:        RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	}
+
+	summary, err := Migrate(context.Background(), config, users)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if summary.Generated != 2 || summary.Exchanged != 2 || summary.Failed != 1 {
+		t.Errorf("summary = %+v, want 2 generated, 2 exchanged and 1 failed", summary)
+	}
+
+	// the invalid sub is not retried, the server error is
+	failed, _ := store.Load(context.Background(), "u2")
+	if failed.State != MigrationFailed || failed.ErrorCode != "invalid_request" || failed.Attempts != 1 {
+		t.Errorf("entry of u2 = %+v, want failed with invalid_request after 1 attempt", failed)
+	}
+	retried, _ := store.Load(context.Background(), "u3")
+	if retried.State != MigrationExchanged || retried.Attempts != 3 {
+		t.Errorf("entry of u3 = %+v, want exchanged after 3 attempts", retried)
+	}
+
+	// a run skipping the failed users sends no request
+	config.SkipFailed = true
+	summary, err = Migrate(context.Background(), config, users)
+	if err != nil || summary.Skipped != 3 || stub.requests["u2"] != 1 {
+		t.Errorf("Migrate() skipping failed = %+v, %v after %d requests of u2, want 3 skipped", summary, err, stub.requests["u2"])
+	}
+}
+
+func TestMigrateTransferExpired(t *testing.T) {
+	stub := &migrationStub{
+		generate: generated,
+		exchange: func(string) *Response {
+			return &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"invalid_grant","error_description":"The transfer period has expired"}`)}
+		},
+	}
+	_, recipient := newMigrationTeams(t, stub)
+	store := NewMemoryMigrationStore()
+	_ = store.Save(context.Background(), MigrationEntry{Sub: "u1", State: MigrationTransferSubGenerated, TransferSub: "t.u1"})
+
+	summary, err := Migrate(context.Background(), MigrationConfig{Recipient: recipient, Store: store}, []MigrationUser{{Sub: "u1"}})
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if summary.Failed != 1 {
+		t.Errorf("summary = %+v, want 1 failed", summary)
+	}
+
+	entry, _ := store.Load(context.Background(), "u1")
+	if entry.State != MigrationFailed || entry.ErrorCode != "invalid_grant" || !strings.Contains(entry.Error, "transfer period has expired") {
+		t.Errorf("entry = %+v, want failed with the expired transfer", entry)
+	}
+	if entry.TransferSub != "t.u1" || stub.requests["t.u1"] != 1 {
+		t.Errorf("entry = %+v after %d requests, want the transfer_sub kept and no retry", entry, stub.requests["t.u1"])
+	}
+}
@@ -1,5 +1,13 @@
 package apple
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Keys is an object that defines a single JSON Web Key.
 type Keys struct {
 	KTY string `json:"kty"` // The key type parameter setting. You must set to "RSA".
@@ -16,11 +24,11 @@ type JWKSet struct {
 }
 
 type TokenResponse struct {
-	AccessToken  string `json:"access_token"`  // (Reserved for future use) A token used to access allowed data. Currently, no data set has been defined for access.
+	AccessToken  Secret `json:"access_token"`  // (Reserved for future use) A token used to access allowed data. Currently, no data set has been defined for access.
 	TokenType    string `json:"token_type"`    // The type of access token. It will always be bearer.
 	ExpiresIn    int    `json:"expires_in"`    // The amount of time, in seconds, before the access token expires.
-	RefreshToken string `json:"refresh_token"` // The refresh token used to regenerate new access tokens. Store this token securely on your server.
-	IDToken      string `json:"id_token"`      // A JSON Web Token that contains the user’s identity information.
+	RefreshToken Secret `json:"refresh_token"` // The refresh token used to regenerate new access tokens. Store this token securely on your server.
+	IDToken      Secret `json:"id_token"`      // A JSON Web Token that contains the user’s identity information.
 
 	// A string that describes the reason for the unsuccessful request.
 	// The string consists of a single allowed value.
@@ -39,11 +47,31 @@ type RevokeResponse struct {
 	// 	invalid_request, invalid_client, invalid_grant, unauthorized_client, unsupported_grant_type, invalid_scope
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"` // More detailed precision about the current error.
+
+	StatusCode int               `json:"-"` // The HTTP status code of the response, of an error too. Zero if no response was received.
+	TypeHint   TokenTypeHint     `json:"-"` // The token_type_hint of the request, filled by RevokeToken.
+	Outcome    RevocationOutcome `json:"-"` // The outcome of the revocation, filled by RevokeToken.
+}
+
+// RevokeRequest is the request of RevokeToken.
+type RevokeRequest struct {
+	ClientID     string        // The identifier (App ID or Services ID) the token was issued to.
+	ClientSecret Secret        // The client secret of ClientID.
+	Token        Secret        // The token to revoke.
+	TypeHint     TokenTypeHint // The type of the token, leave it empty if unknown.
+
+	// TryOtherHint tries again with the other type of token, when Apple
+	// rejects the token with TypeHint. If TypeHint is empty, the token is
+	// tried as a refresh token, then as an access token.
+	TryOtherHint bool
 }
 
 type GenerateTransferSubResponse struct {
 	// TransferSub is the transfer identifier for the user that you send to the recipient team
 	TransferSub string `json:"transfer_sub"`
+
+	Error            string `json:"error"`             // A string that describes the reason for the unsuccessful request.
+	ErrorDescription string `json:"error_description"` // More detailed precision about the current error.
 }
 
 type ExchangeIdentifierResponse struct {
@@ -57,4 +85,146 @@ type ExchangeIdentifierResponse struct {
 
 	// IsPrivateEmail specifies if the email address provided is the private mail relay address.
 	IsPrivateEmail bool `json:"is_private_email"`
+
+	Error            string `json:"error"`             // A string that describes the reason for the unsuccessful request.
+	ErrorDescription string `json:"error_description"` // More detailed precision about the current error.
+}
+
+// TransferredUser is a user signing in with an id_token carrying a
+// transfer_sub claim, that is a user transferred from another team whose
+// identifier is not exchanged yet. See ResolveTransferSub.
+type TransferredUser struct {
+	// TransferSub is the transfer identifier of the user, which the
+	// transferring team generated from the user identifier in that team.
+	TransferSub string
+
+	// Sub is the sub claim of the id_token.
+	Sub string
+
+	// NewSub is the user identifier in the recipient team, returned by the
+	// resolver. It is empty if there is no resolver.
+	NewSub string
+
+	// Email is the private email address specific to the recipient team, if
+	// any, returned by the resolver.
+	Email string
+
+	// IsPrivateEmail specifies if Email is the private mail relay address.
+	IsPrivateEmail bool
+}
+
+// NotificationEventType is the type of a server-to-server notification
+// event.
+type NotificationEventType string
+
+const (
+	NotificationEmailDisabled  NotificationEventType = "email-disabled"  // The user stopped receiving emails through the private relay email.
+	NotificationEmailEnabled   NotificationEventType = "email-enabled"   // The user started receiving emails through the private relay email again.
+	NotificationConsentRevoked NotificationEventType = "consent-revoked" // The user stopped using Sign in with Apple with your app.
+	NotificationAccountDelete  NotificationEventType = "account-delete"  // The user deleted their Apple Account.
+)
+
+// Notification is a server-to-server notification sent by Apple, see
+// ParseNotification.
+type Notification struct {
+	Issuer   string            // The iss claim, always "https://appleid.apple.com".
+	Audience string            // The client ID the notification is for.
+	IssuedAt time.Time         // When Apple issued the notification.
+	ID       string            // The unique identifier of the notification, the jti claim.
+	Event    NotificationEvent // The event of the notification.
+}
+
+// NotificationEvent is the event of a server-to-server notification, the
+// events claim of the notification.
+type NotificationEvent struct {
+	Type           NotificationEventType `json:"type"`                       // The type of the event.
+	Sub            string                `json:"sub"`                        // The user identifier.
+	Email          string                `json:"email,omitempty"`            // The email of the user, for email events.
+	IsPrivateEmail bool                  `json:"is_private_email,omitempty"` // Whether Email is a private relay email.
+	EventTime      time.Time             `json:"event_time"`                 // When the event happened.
+}
+
+// UnmarshalJSON decodes the event as Apple sends it: is_private_email may be
+// a string "true" or "false", and event_time is in milliseconds since the
+// Unix epoch.
+func (e *NotificationEvent) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type           NotificationEventType `json:"type"`
+		Sub            string                `json:"sub"`
+		Email          string                `json:"email"`
+		IsPrivateEmail json.RawMessage       `json:"is_private_email"`
+		EventTime      json.Number           `json:"event_time"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	isPrivateEmail, err := parseStringBool(raw.IsPrivateEmail)
+	if err != nil {
+		return fmt.Errorf("invalid is_private_email: %w", err)
+	}
+
+	var eventTime time.Time
+	if raw.EventTime != "" {
+		ms, err := raw.EventTime.Int64()
+		if err != nil {
+			return fmt.Errorf("invalid event_time: %w", err)
+		}
+		eventTime = time.UnixMilli(ms).UTC()
+	}
+
+	*e = NotificationEvent{
+		Type:           raw.Type,
+		Sub:            raw.Sub,
+		Email:          raw.Email,
+		IsPrivateEmail: isPrivateEmail,
+		EventTime:      eventTime,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the event as Apple sends it.
+func (e NotificationEvent) MarshalJSON() ([]byte, error) {
+	raw := struct {
+		Type           NotificationEventType `json:"type"`
+		Sub            string                `json:"sub"`
+		Email          string                `json:"email,omitempty"`
+		IsPrivateEmail string                `json:"is_private_email,omitempty"`
+		EventTime      int64                 `json:"event_time,omitempty"`
+	}{
+		Type:  e.Type,
+		Sub:   e.Sub,
+		Email: e.Email,
+	}
+	if e.Email != "" || e.IsPrivateEmail {
+		raw.IsPrivateEmail = strconv.FormatBool(e.IsPrivateEmail)
+	}
+	if !e.EventTime.IsZero() {
+		raw.EventTime = e.EventTime.UnixMilli()
+	}
+	return json.Marshal(raw)
+}
+
+// parseStringBool parses a boolean that Apple may send as a JSON boolean or
+// as a string
+func parseStringBool(data json.RawMessage) (bool, error) {
+	var value any
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return false, err
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case string:
+		if v == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(strings.ToLower(v))
+	default:
+		return false, fmt.Errorf("unexpected value %s", data)
+	}
 }
@@ -0,0 +1,172 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/cast"
+)
+
+// NotificationHandlerConfig configures NewNotificationHandler.
+type NotificationHandlerConfig struct {
+	// ClientIDs are the client IDs the notifications are accepted for, one
+	// of them must be the audience of the notification. Required.
+	ClientIDs []string
+
+	// The callbacks of the events, an event without callback is accepted
+	// and ignored. An error of a callback responds 500, so that Apple sends
+	// the notification again later.
+	OnEmailDisabled  func(ctx context.Context, event *NotificationEvent) error
+	OnEmailEnabled   func(ctx context.Context, event *NotificationEvent) error
+	OnConsentRevoked func(ctx context.Context, event *NotificationEvent) error
+	OnAccountDelete  func(ctx context.Context, event *NotificationEvent) error
+
+	// OnError receives the notifications rejected and the errors of the
+	// callbacks. Optional.
+	OnError func(r *http.Request, err error)
+}
+
+// maxNotificationSize is the largest notification body accepted
+const maxNotificationSize = 64 * 1024
+
+// NewNotificationHandler returns the http.Handler of the server-to-server
+// notification endpoint registered in the Apple Developer Portal.
+//
+// Apple POSTs a JSON body with a payload JWT. The handler verifies the JWT
+// with Apple's public keys of the client, checks its issuer and audience,
+// decodes its events claim, and calls the callback of the event. It
+// responds:
+//
+//	200 when the event is handled, or has no callback
+//	400 when the notification is malformed, or fails verification
+//	405 when the method is not POST
+//	500 when the callback fails, or the notification is signed with a key
+//	    still unknown after Apple's public keys are fetched again, so that
+//	    Apple sends it again later
+//
+// Ref: https://developer.apple.com/documentation/sign_in_with_apple/processing-changes-for-sign-in-with-apple-accounts
+func NewNotificationHandler(client Client, config NotificationHandlerConfig) (http.Handler, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if len(config.ClientIDs) == 0 {
+		return nil, errors.New("at least one client ID is required")
+	}
+	return &notificationHandler{client: client, config: config}, nil
+}
+
+type notificationHandler struct {
+	client Client
+	config NotificationHandlerConfig
+}
+
+func (h *notificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxNotificationSize)).Decode(&body); err != nil {
+		h.fail(w, r, http.StatusBadRequest, fmt.Errorf("invalid notification body: %w", err))
+		return
+	}
+
+	notification, err := ParseNotification(h.client, body.Payload, h.config.ClientIDs)
+	if errors.Is(err, errMissingPublicKey) {
+		h.fail(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	event := &notification.Event
+	var callback func(ctx context.Context, event *NotificationEvent) error
+	switch event.Type {
+	case NotificationEmailDisabled:
+		callback = h.config.OnEmailDisabled
+	case NotificationEmailEnabled:
+		callback = h.config.OnEmailEnabled
+	case NotificationConsentRevoked:
+		callback = h.config.OnConsentRevoked
+	case NotificationAccountDelete:
+		callback = h.config.OnAccountDelete
+	}
+	if callback != nil {
+		if err = callback(r.Context(), event); err != nil {
+			h.fail(w, r, http.StatusInternalServerError, fmt.Errorf("failed to handle %s event: %w", event.Type, err))
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *notificationHandler) fail(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if h.config.OnError != nil {
+		h.config.OnError(r, err)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// ParseNotification verifies the payload JWT of a server-to-server
+// notification with Apple's public keys of the client, checks its issuer and
+// that its audience is one of clientIDs, and decodes it. It is the parsing
+// of NewNotificationHandler, for notifications received by other means, such
+// as a queue.
+func ParseNotification(client Client, payload string, clientIDs []string) (*Notification, error) {
+	if payload == "" {
+		return nil, errors.New("no payload in notification")
+	}
+
+	_, token, err := client.VerifyTokenSignature(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification payload: %w", err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+
+	notification := &Notification{}
+	for _, clientID := range clientIDs {
+		if claims.VerifyAudience(clientID, true) {
+			notification.Audience = clientID
+			break
+		}
+	}
+	if notification.Audience == "" {
+		return nil, fmt.Errorf("notification is not for the client IDs: %w", jwt.ErrTokenInvalidAudience)
+	}
+	notification.Issuer = cast.ToString(claims["iss"])
+	notification.ID = cast.ToString(claims["jti"])
+	if iat, ok := claims["iat"].(float64); ok {
+		notification.IssuedAt = time.Unix(int64(iat), 0).UTC()
+	}
+
+	// the events claim is a JSON object encoded as a string
+	var raw []byte
+	switch events := claims["events"].(type) {
+	case string:
+		raw = []byte(events)
+	case map[string]any:
+		raw, _ = json.Marshal(events)
+	default:
+		return nil, errors.New("no events in notification payload")
+	}
+	if err = json.Unmarshal(raw, &notification.Event); err != nil {
+		return nil, fmt.Errorf("invalid events in notification payload: %w", err)
+	}
+	if notification.Event.Type == "" || notification.Event.Sub == "" {
+		return nil, errors.New("no type or sub in notification events")
+	}
+
+	return notification, nil
+}
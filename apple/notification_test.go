@@ -0,0 +1,252 @@
+package apple
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// notificationKeys signs notifications in place of Apple, and serves the
+// public key as one of Apple's public keys once it is published
+type notificationKeys struct {
+	key       *rsa.PrivateKey
+	published atomic.Bool
+	fetches   atomic.Int32
+}
+
+func newNotificationKeys(t *testing.T, published bool) *notificationKeys {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &notificationKeys{key: key}
+	k.published.Store(published)
+	return k
+}
+
+// client creates a client fetching the public keys from k
+func (k *notificationKeys) client(t *testing.T) Client {
+	t.Helper()
+	stub := func(Doer) Doer {
+		return DoerFunc(func(_ context.Context, req *Request) (*Response, error) {
+			if req.Endpoint != EndpointKeys {
+				return &Response{StatusCode: http.StatusNotFound}, nil
+			}
+			k.fetches.Add(1)
+			set := JWKSet{Keys: []*Keys{{KTY: "RSA", KID: "other", N: "AQAB", E: "AQAB"}}}
+			if k.published.Load() {
+				set.Keys = append(set.Keys, &Keys{
+					KTY: "RSA",
+					KID: "notify",
+					ALG: "RS256",
+					N:   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+					E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+				})
+			}
+			body, _ := json.Marshal(set)
+			return &Response{StatusCode: http.StatusOK, Body: body}, nil
+		})
+	}
+
+	c, err := NewClient(WithMiddleware(stub))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(c.(*client).Close)
+	return c
+}
+
+// signNotification returns the payload of a notification of events for aud,
+// signed with key
+func signNotification(t *testing.T, key *rsa.PrivateKey, aud, events string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":    baseURL,
+		"aud":    aud,
+		"iat":    time.Now().Unix(),
+		"jti":    "n-1",
+		"events": events,
+	})
+	token.Header["kid"] = "notify"
+	payload, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// notify posts the payload to handler and returns the status code
+func notify(handler http.Handler, payload string) int {
+	body, _ := json.Marshal(map[string]string{"payload": payload})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/apple/notifications", strings.NewReader(string(body))))
+	return w.Code
+}
+
+const accountDeleteEvents = `{"type":"account-delete","sub":"001234.abcd","event_time":1700000000000}`
+
+func TestNotificationHandler(t *testing.T) {
+	keys := newNotificationKeys(t, true)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	handler, err := NewNotificationHandler(keys.client(t), NotificationHandlerConfig{
+		ClientIDs: []string{"com.example.app", "com.example.web"},
+		OnAccountDelete: func(_ context.Context, event *NotificationEvent) error {
+			deleted = append(deleted, event.Sub)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewNotificationHandler() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		payload     string
+		wantStatus  int
+		wantDeleted bool
+	}{
+		{"happy path", signNotification(t, keys.key, "com.example.web", accountDeleteEvents), http.StatusOK, true},
+		{"event without callback", signNotification(t, keys.key, "com.example.app", `{"type":"email-enabled","sub":"001234.abcd"}`), http.StatusOK, false},
+		{"other audience", signNotification(t, keys.key, "com.other.app", accountDeleteEvents), http.StatusBadRequest, false},
+		{"bad signature", signNotification(t, other, "com.example.app", accountDeleteEvents), http.StatusBadRequest, false},
+		{"no events", signNotification(t, keys.key, "com.example.app", `{}`), http.StatusBadRequest, false},
+		{"malformed", "not.a.jwt", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted = nil
+			if status := notify(handler, tt.payload); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if got := len(deleted) == 1 && deleted[0] == "001234.abcd"; got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want deleted %t", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestNotificationHandlerRefreshesUnknownKey(t *testing.T) {
+	keys := newNotificationKeys(t, false)
+	handler, err := NewNotificationHandler(keys.client(t), NotificationHandlerConfig{ClientIDs: []string{"com.example.app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := signNotification(t, keys.key, "com.example.app", accountDeleteEvents)
+
+	// a key still unknown once the keys are fetched again is left for later
+	if status := notify(handler, payload); status != http.StatusInternalServerError {
+		t.Errorf("status of an unknown key = %d, want 500", status)
+	}
+	if n := keys.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+
+	// the keys are not fetched again within a minute
+	keys.published.Store(true)
+	if status := notify(handler, payload); status != http.StatusInternalServerError {
+		t.Errorf("status of an unknown key fetched recently = %d, want 500", status)
+	}
+	if n := keys.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+func TestNotificationHandlerFetchesNewKey(t *testing.T) {
+	keys := newNotificationKeys(t, false)
+	handler, err := NewNotificationHandler(keys.client(t), NotificationHandlerConfig{ClientIDs: []string{"com.example.app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apple rotated its keys since the client fetched them
+	keys.published.Store(true)
+	if status := notify(handler, signNotification(t, keys.key, "com.example.app", accountDeleteEvents)); status != http.StatusOK {
+		t.Errorf("status = %d, want 200", status)
+	}
+	if n := keys.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+func TestNewNotificationHandlerRequiresClientIDs(t *testing.T) {
+	keys := newNotificationKeys(t, true)
+	if _, err := NewNotificationHandler(keys.client(t), NotificationHandlerConfig{}); err == nil {
+		t.Error("NewNotificationHandler() without client IDs error = nil, want an error")
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	keys := newNotificationKeys(t, true)
+	c := keys.client(t)
+	eventTime := time.UnixMilli(1700000000123).UTC()
+
+	tests := []struct {
+		name    string
+		events  string
+		want    NotificationEvent
+		wantErr bool
+	}{
+		{
+			name:   "email disabled with string private email",
+			events: `{"type":"email-disabled","sub":"001234.abcd","email":"x@privaterelay.appleid.com","is_private_email":"true","event_time":1700000000123}`,
+			want:   NotificationEvent{Type: NotificationEmailDisabled, Sub: "001234.abcd", Email: "x@privaterelay.appleid.com", IsPrivateEmail: true, EventTime: eventTime},
+		},
+		{
+			name:   "email enabled with bool private email",
+			events: `{"type":"email-enabled","sub":"001234.abcd","email":"x@privaterelay.appleid.com","is_private_email":true,"event_time":1700000000123}`,
+			want:   NotificationEvent{Type: NotificationEmailEnabled, Sub: "001234.abcd", Email: "x@privaterelay.appleid.com", IsPrivateEmail: true, EventTime: eventTime},
+		},
+		{
+			name:   "email enabled with string false",
+			events: `{"type":"email-enabled","sub":"001234.abcd","email":"a@example.com","is_private_email":"false","event_time":1700000000123}`,
+			want:   NotificationEvent{Type: NotificationEmailEnabled, Sub: "001234.abcd", Email: "a@example.com", EventTime: eventTime},
+		},
+		{
+			name:   "consent revoked without event time",
+			events: `{"type":"consent-revoked","sub":"001234.abcd"}`,
+			want:   NotificationEvent{Type: NotificationConsentRevoked, Sub: "001234.abcd"},
+		},
+		{
+			name:   "account delete",
+			events: accountDeleteEvents,
+			want:   NotificationEvent{Type: NotificationAccountDelete, Sub: "001234.abcd", EventTime: time.UnixMilli(1700000000000).UTC()},
+		},
+		{name: "invalid private email", events: `{"type":"email-enabled","sub":"001234.abcd","is_private_email":"maybe"}`, wantErr: true},
+		{name: "invalid event time", events: `{"type":"account-delete","sub":"001234.abcd","event_time":"soon"}`, wantErr: true},
+		{name: "no sub", events: `{"type":"account-delete"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification, err := ParseNotification(c, signNotification(t, keys.key, "com.example.app", tt.events), []string{"com.example.app"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNotification() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if notification.Event != tt.want {
+				t.Errorf("Event = %+v, want %+v", notification.Event, tt.want)
+			}
+			if notification.Issuer != baseURL || notification.Audience != "com.example.app" || notification.ID != "n-1" || notification.IssuedAt.IsZero() {
+				t.Errorf("notification = %+v, want the claims of the payload", notification)
+			}
+		})
+	}
+}
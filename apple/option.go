@@ -1,5 +1,10 @@
 package apple
 
+import (
+	"log/slog"
+	"time"
+)
+
 type Option func(*client)
 
 // WithUpdatePubkeyFailedHandler allow you to do something when the updater failed to
@@ -11,3 +16,116 @@ func WithUpdatePubkeyFailedHandler(fn func()) Option {
 		}
 	}
 }
+
+// WithExchangeHook allow you to receive a redacted record of every HTTP
+// exchange with Apple, including the public key fetches. It is useful for
+// debugging integration failures such as an unexpected invalid_grant.
+//
+// Use NewJSONLExchangeWriter to dump the exchanges into an io.Writer.
+func WithExchangeHook(hook ExchangeHook) Option {
+	return func(c *client) {
+		if hook != nil {
+			c.exchange = NewExchangeMiddleware(hook)
+		}
+	}
+}
+
+// WithRetryPolicy allow the client to retry failed requests to Apple with an
+// exponential backoff. The client does not retry by default.
+//
+// Whatever the policy is, an authorization code is never sent twice, since
+// it is single-use only. See DefaultRetryClassifier.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *client) {
+		c.retry = NewRetryMiddleware(policy)
+	}
+}
+
+// WithBreakerPolicy guards every endpoint with a circuit breaker and a limit
+// of requests in flight, so that callers fail fast with ErrCircuitOpen or
+// ErrTooManyInFlight instead of piling up while Apple is unavailable.
+func WithBreakerPolicy(policy BreakerPolicy) Option {
+	return func(c *client) {
+		c.breaker = NewBreakerMiddleware(policy)
+	}
+}
+
+// WithAudience makes VerifyTokenSignature reject an id_token whose aud claim
+// is none of the client IDs, such as an id_token issued for another app.
+// Without it, VerifyTokenSignature does not check the audience at all.
+func WithAudience(clientIDs ...string) Option {
+	return func(c *client) {
+		c.audience = append(c.audience, clientIDs...)
+	}
+}
+
+// WithRateLimitPolicy limits the rate of requests sent to Apple per endpoint
+// and per client ID. Limited requests either wait or fail fast with
+// ErrRateLimited, as the policy says.
+func WithRateLimitPolicy(policy RateLimitPolicy) Option {
+	return func(c *client) {
+		c.limiter = NewRateLimitMiddleware(policy)
+	}
+}
+
+// WithMetrics reports the measurements of the client to metrics: the calls
+// to every endpoint, the fetches of Apple's public keys and the id_token
+// verifications. See NewExpvarMetrics for a ready-made implementation.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *client) {
+		if metrics != nil {
+			c.metrics = metrics
+			c.measure = NewMetricsMiddleware(metrics)
+		}
+	}
+}
+
+// WithLogger logs the requests to Apple, the fetches of Apple's public keys
+// and the id_token verifications with logger. Secrets and tokens are never
+// logged. The client logs nothing by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *client) {
+		if logger != nil {
+			c.logger = logger
+			c.log = NewLoggingMiddleware(logger)
+		}
+	}
+}
+
+// WithAuditSink records every revocation and user migration request sent to
+// Apple into sink. Use ContextWithAuditSubject to record the user they are
+// made for, and OpenFileAuditSink for a tamper-evident audit log. Set
+// DeleteAccountRequest.Audit to record the account deletions as well.
+func WithAuditSink(sink AuditSink) Option {
+	return func(c *client) {
+		if sink != nil {
+			c.auditSink = sink
+		}
+	}
+}
+
+// WithClientSecretExpiryWarning calls warn when a request is sent with a
+// client_secret expiring within the given duration, such as 30 days, so that
+// it is renewed before Apple rejects it. See
+// NewClientSecretExpiryMiddleware.
+func WithClientSecretExpiryWarning(within time.Duration, warn func(info *ClientSecretInfo)) Option {
+	return func(c *client) {
+		if warn != nil {
+			c.middlewares = append(c.middlewares, NewClientSecretExpiryMiddleware(within, warn))
+		}
+	}
+}
+
+// WithMiddleware wraps every request to Apple, including the public key
+// fetches, with the middlewares. The first middleware is the outermost one.
+//
+// The middlewares run once per call, outside of the built-in middlewares,
+// which run in this order: metrics, retry, logging, rate limit, circuit
+// breaker and exchange hook. Use the middleware constructors, such as
+// NewRetryMiddleware, to compose the built-in middlewares in a different
+// order.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *client) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
@@ -0,0 +1,168 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned without calling Apple when a request exceeds the
+// client-side rate limit and the RateLimitPolicy does not wait.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimit is the configuration of a token bucket.
+type RateLimit struct {
+	Rate  float64 // The number of requests allowed per second. Zero or less means unlimited.
+	Burst int     // The maximum number of requests allowed at once. Defaults to 1.
+}
+
+func (l RateLimit) unlimited() bool {
+	return l.Rate <= 0
+}
+
+// RateLimitPolicy limits the rate of requests sent to Apple, so that bulk
+// jobs do not get the client ID throttled for live sign-ins.
+//
+// A request takes a token from the bucket of its endpoint and a token from
+// the bucket of its client ID, which is shared across endpoints. The buckets
+// are shared by every goroutine using the client.
+type RateLimitPolicy struct {
+	// Endpoints limits the requests to each endpoint, across all client IDs.
+	Endpoints map[Endpoint]RateLimit
+
+	// ClientIDs limits the requests of each client ID, across all endpoints.
+	ClientIDs map[string]RateLimit
+
+	// PerClientID limits the requests of the client IDs not listed in
+	// ClientIDs.
+	PerClientID RateLimit
+
+	// Wait makes a limited request wait until it is allowed, or until its
+	// context is done. Otherwise, it fails fast with ErrRateLimited.
+	Wait bool
+}
+
+// NewRateLimitMiddleware returns a Middleware which limits the rate of
+// requests per endpoint and per client ID. See WithRateLimitPolicy.
+func NewRateLimitMiddleware(policy RateLimitPolicy) Middleware {
+	l := newLimiter(policy)
+	return func(next Doer) Doer {
+		return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+			if err := l.wait(ctx, req.Endpoint, req.Form["client_id"]); err != nil {
+				return nil, err
+			}
+			return next.Do(ctx, req)
+		})
+	}
+}
+
+// limiter holds the token buckets of a RateLimitPolicy
+type limiter struct {
+	policy RateLimitPolicy
+
+	mu        sync.Mutex
+	endpoints map[Endpoint]*bucket
+	clientIDs map[string]*bucket
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(policy RateLimitPolicy) *limiter {
+	return &limiter{
+		policy:    policy,
+		endpoints: make(map[Endpoint]*bucket),
+		clientIDs: make(map[string]*bucket),
+	}
+}
+
+func newBucket(limit RateLimit, now time.Time) *bucket {
+	if limit.unlimited() {
+		return nil
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	return &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// refill adds the tokens earned since the last refill, and returns how long
+// to wait until a token is available
+func (b *bucket) refill(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func (b *bucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+// wait takes a token from the buckets of the endpoint and of the client ID,
+// it waits for the tokens or fails with ErrRateLimited as the policy says. A
+// nil limiter never waits.
+func (l *limiter) wait(ctx context.Context, endpoint Endpoint, clientID string) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		d := l.reserve(endpoint, clientID)
+		if d == 0 {
+			return nil
+		}
+		if !l.policy.Wait {
+			return fmt.Errorf("%w: %s", ErrRateLimited, endpoint)
+		}
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes the tokens if both buckets have one, otherwise it returns
+// how long to wait before trying again
+func (l *limiter) reserve(endpoint Endpoint, clientID string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	eb, ok := l.endpoints[endpoint]
+	if !ok {
+		eb = newBucket(l.policy.Endpoints[endpoint], now)
+		l.endpoints[endpoint] = eb
+	}
+
+	var cb *bucket
+	if clientID != "" {
+		if cb, ok = l.clientIDs[clientID]; !ok {
+			limit, ok := l.policy.ClientIDs[clientID]
+			if !ok {
+				limit = l.policy.PerClientID
+			}
+			cb = newBucket(limit, now)
+			l.clientIDs[clientID] = cb
+		}
+	}
+
+	if d := max(eb.refill(now), cb.refill(now)); d > 0 {
+		return d
+	}
+	eb.take()
+	cb.take()
+	return 0
+}
@@ -0,0 +1,222 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy describes how the client retries failed requests to Apple.
+//
+// The zero value disables retries, which is the default behavior of the
+// client. Use DefaultRetryPolicy for a reasonable starting point.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request, including
+	// the first one. A value less than 2 disables retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, it doubles on every
+	// following retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between two attempts, including the delay
+	// requested by a Retry-After header. Zero means no cap.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction, between 0 and 1, of every delay that is
+	// randomized to avoid retrying in lockstep with other clients.
+	Jitter float64
+
+	// Classify reports whether a failed attempt can be retried. When nil,
+	// DefaultRetryClassifier is used. An authorization_code grant that was
+	// sent is never retried, whatever Classify reports.
+	Classify func(attempt RetryAttempt) bool
+}
+
+// RetryAttempt describes a failed attempt, it is given to the classifier of
+// a RetryPolicy.
+type RetryAttempt struct {
+	Endpoint   Endpoint // The endpoint requested.
+	GrantType  string   // The grant_type of a token request, empty for other endpoints.
+	Attempt    int      // The number of this attempt, starting from 1.
+	Sent       bool     // Whether the request was completely written to the connection.
+	StatusCode int      // The response status code, 0 if no response was received.
+	Err        error    // The transport error, if any.
+}
+
+// DefaultRetryPolicy returns a policy of 3 attempts, with an exponential
+// backoff from 200ms up to 5s and 20% of jitter.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// DefaultRetryClassifier is the default retry classification of every
+// endpoint:
+//
+//   - An authorization code is single-use, so an authorization_code grant is
+//     retried only when the request never reached the connection.
+//   - Other requests are retried on transport errors, 429 Too Many Requests
+//     and 5xx server errors.
+func DefaultRetryClassifier(attempt RetryAttempt) bool {
+	if isAuthorizationCodeGrant(attempt) {
+		return !attempt.Sent
+	}
+	if attempt.Err != nil {
+		return true
+	}
+	return attempt.StatusCode == http.StatusTooManyRequests || attempt.StatusCode >= http.StatusInternalServerError
+}
+
+func isAuthorizationCodeGrant(attempt RetryAttempt) bool {
+	return attempt.Endpoint == EndpointToken && attempt.GrantType == "authorization_code"
+}
+
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+func (p RetryPolicy) shouldRetry(ctx context.Context, attempt RetryAttempt) bool {
+	if attempt.Attempt >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	if attempt.Err == nil && attempt.StatusCode < http.StatusBadRequest {
+		return false
+	}
+	if errors.Is(attempt.Err, ErrCircuitOpen) || errors.Is(attempt.Err, ErrTooManyInFlight) || errors.Is(attempt.Err, ErrRateLimited) {
+		return false
+	}
+	if isAuthorizationCodeGrant(attempt) && attempt.Sent {
+		return false // whatever the classifier says
+	}
+	if p.Classify != nil {
+		return p.Classify(attempt)
+	}
+	return DefaultRetryClassifier(attempt)
+}
+
+// delay returns how long to wait before the next attempt, retryAfter is the
+// value of the Retry-After header of the failed attempt, if any
+func (p RetryPolicy) delay(attempt int, retryAfter string) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d < 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 && d > 0 {
+		jitter := time.Duration(p.Jitter * float64(d))
+		d = d - jitter + time.Duration(rand.Int64N(int64(2*jitter)+1))
+	}
+	if after, ok := parseRetryAfter(retryAfter); ok && after > d {
+		d = after
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// jobRetryPolicy returns the retry policy of a job built on top of a Client,
+// such as Migrate: DefaultRetryPolicy unless MaxAttempts is set, so that a
+// MaxAttempts of 1 leaves the retries to the client
+func jobRetryPolicy(p RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		return DefaultRetryPolicy()
+	}
+	return p
+}
+
+// retryJob runs a step of a job built on top of a Client, and runs it again
+// as the policy allows while it fails temporarily. The Client may be of your
+// own, so the retry middleware cannot be relied on. The error of the last
+// attempt is returned.
+func retryJob(ctx context.Context, policy RetryPolicy, step func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := step()
+		if err == nil || !isTemporary(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		if sleep(ctx, policy.delay(attempt, "")) != nil {
+			return err
+		}
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds >= 0
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at), true
+	}
+	return 0, false
+}
+
+// NewRetryMiddleware returns a Middleware which retries failed requests as
+// the policy allows. See WithRetryPolicy.
+func NewRetryMiddleware(policy RetryPolicy) Middleware {
+	return func(next Doer) Doer {
+		if !policy.enabled() {
+			return next
+		}
+		return DoerFunc(func(ctx context.Context, req *Request) (rsp *Response, err error) {
+			for attempt := 1; ; attempt++ {
+				// the transport may report the write from another goroutine
+				var sent atomic.Bool
+				trace := &httptrace.ClientTrace{
+					WroteRequest: func(httptrace.WroteRequestInfo) { sent.Store(true) },
+				}
+
+				rsp, err = next.Do(httptrace.WithClientTrace(ctx, trace), req)
+
+				failed := RetryAttempt{
+					Endpoint:   req.Endpoint,
+					GrantType:  req.Form["grant_type"],
+					Attempt:    attempt,
+					Sent:       sent.Load(),
+					StatusCode: statusCodeOf(rsp),
+					Err:        err,
+				}
+				if !policy.shouldRetry(ctx, failed) {
+					return rsp, err
+				}
+
+				var retryAfter string
+				if rsp != nil {
+					retryAfter = rsp.Header.Get("Retry-After")
+				}
+				if sleep(ctx, policy.delay(attempt, retryAfter)) != nil {
+					return rsp, err
+				}
+			}
+		})
+	}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// httpDoer returns a Doer sending the requests to server, with ctx, so that
+// the httptrace of the retry middleware sees them written
+func httpDoer(server *httptest.Server) Doer {
+	return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		form := url.Values{}
+		for key, value := range req.Form {
+			form.Set(key, value)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.Method, server.URL+string(req.Endpoint), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set(headerContentType, headerValueContentType)
+		httpRsp, err := server.Client().Do(httpReq)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = httpRsp.Body.Close() }()
+		body, err := io.ReadAll(httpRsp.Body)
+		return &Response{StatusCode: httpRsp.StatusCode, Header: httpRsp.Header, Body: body}, err
+	})
+}
+
+// newStatusServer returns a server answering the statuses in turn, then 200,
+// and counting the requests it received
+func newStatusServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryMiddlewareNeverResendsAuthorizationCode(t *testing.T) {
+	server, requests := newStatusServer(t, nil, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Classify: func(RetryAttempt) bool { return true }}
+	doer := NewRetryMiddleware(policy)(httpDoer(server))
+
+	rsp, err := doer.Do(context.Background(), &Request{
+		Method:   http.MethodPost,
+		Endpoint: EndpointToken,
+		Form:     map[string]string{"grant_type": "authorization_code", "code": "c0de"},
+	})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rsp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1, an authorization code is never sent twice", n)
+	}
+}
+
+func TestRetryMiddlewareRetriesUnsentAuthorizationCode(t *testing.T) {
+	var attempts int
+	doer := NewRetryMiddleware(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})(DoerFunc(func(context.Context, *Request) (*Response, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("dial tcp: connection refused")
+		}
+		return &Response{StatusCode: http.StatusOK}, nil
+	}))
+
+	_, err := doer.Do(context.Background(), &Request{Endpoint: EndpointToken, Form: map[string]string{"grant_type": "authorization_code"}})
+	if err != nil || attempts != 2 {
+		t.Errorf("Do() error = %v after %d attempts, want a success after 2 attempts", err, attempts)
+	}
+}
+
+func TestRetryMiddlewareRetriesRefreshToken(t *testing.T) {
+	server, requests := newStatusServer(t, nil, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	doer := NewRetryMiddleware(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})(httpDoer(server))
+
+	rsp, err := doer.Do(context.Background(), &Request{
+		Method:   http.MethodPost,
+		Endpoint: EndpointToken,
+		Form:     map[string]string{"grant_type": "refresh_token"},
+	})
+	if err != nil || rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Do() = %v, %v, want a success", rsp, err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestRetryMiddlewareDoesNotRetryClientErrors(t *testing.T) {
+	server, requests := newStatusServer(t, nil, http.StatusBadRequest)
+	doer := NewRetryMiddleware(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})(httpDoer(server))
+
+	rsp, _ := doer.Do(context.Background(), &Request{Method: http.MethodPost, Endpoint: EndpointRevoke})
+	if rsp.StatusCode != http.StatusBadRequest || requests.Load() != 1 {
+		t.Errorf("status = %d after %d requests, want 400 after 1 request", rsp.StatusCode, requests.Load())
+	}
+}
+
+func TestRetryMiddlewareHonoursRetryAfter(t *testing.T) {
+	server, requests := newStatusServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	doer := NewRetryMiddleware(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})(httpDoer(server))
+
+	start := time.Now()
+	rsp, err := doer.Do(context.Background(), &Request{Method: http.MethodPost, Endpoint: EndpointRevoke})
+	if err != nil || rsp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Fatalf("Do() = %v, %v after %d requests, want a success after 2 requests", rsp, err, requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the Retry-After of 1s", elapsed)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{"first retry", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 1, "", 100 * time.Millisecond},
+		{"exponential backoff", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 3, "", 400 * time.Millisecond},
+		{"capped backoff", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 250 * time.Millisecond}, 3, "", 250 * time.Millisecond},
+		{"retry after seconds", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 1, "2", 2 * time.Second},
+		{"shorter retry after", RetryPolicy{BaseDelay: 3 * time.Second}, 1, "2", 3 * time.Second},
+		{"capped retry after", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 1, "120", time.Second},
+		{"invalid retry after", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 1, "soon", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.attempt, tt.retryAfter); got != tt.want {
+				t.Errorf("delay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := (RetryPolicy{BaseDelay: time.Millisecond}).delay(1, at); got < 58*time.Second || got > time.Minute {
+		t.Errorf("delay() of an HTTP date a minute ahead = %s, want about a minute", got)
+	}
+}
+
+func TestDefaultRetryClassifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt RetryAttempt
+		want    bool
+	}{
+		{"sent authorization code", RetryAttempt{Endpoint: EndpointToken, GrantType: "authorization_code", Sent: true, StatusCode: 503}, false},
+		{"unsent authorization code", RetryAttempt{Endpoint: EndpointToken, GrantType: "authorization_code", Err: errors.New("dial")}, true},
+		{"refresh token 503", RetryAttempt{Endpoint: EndpointToken, GrantType: "refresh_token", Sent: true, StatusCode: 503}, true},
+		{"revoke 429", RetryAttempt{Endpoint: EndpointRevoke, Sent: true, StatusCode: 429}, true},
+		{"revoke 400", RetryAttempt{Endpoint: EndpointRevoke, Sent: true, StatusCode: 400}, false},
+		{"transport error", RetryAttempt{Endpoint: EndpointRevoke, Sent: true, Err: errors.New("reset")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultRetryClassifier(tt.attempt); got != tt.want {
+				t.Errorf("DefaultRetryClassifier() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package apple
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// TokenTypeHint tells Apple the type of token to revoke.
+type TokenTypeHint string
+
+const (
+	TokenTypeAccessToken  TokenTypeHint = "access_token"
+	TokenTypeRefreshToken TokenTypeHint = "refresh_token"
+)
+
+// RevocationOutcome is the outcome of a token revocation.
+type RevocationOutcome string
+
+const (
+	RevocationRevoked        RevocationOutcome = "revoked"         // Apple revoked the token.
+	RevocationAlreadyInvalid RevocationOutcome = "already_invalid" // The token was already expired or revoked.
+	RevocationFailed         RevocationOutcome = "failed"          // The token may still be valid.
+)
+
+// revocationOutcome classifies the result of a revocation request, Apple
+// answers invalid_grant to a token that is already expired or revoked
+func revocationOutcome(err error) RevocationOutcome {
+	switch {
+	case err == nil:
+		return RevocationRevoked
+	case IsErrorCode(err, "invalid_grant"):
+		return RevocationAlreadyInvalid
+	default:
+		return RevocationFailed
+	}
+}
+
+// isTemporary reports whether a failed request may succeed if it is sent
+// again later
+func isTemporary(err error) bool {
+	var e *Error
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &e):
+		return e.Temporary()
+	case errors.Is(err, context.Canceled):
+		return false
+	default:
+		return true // transport errors and client-side limits
+	}
+}
+
+// TokenFingerprint returns a fingerprint of token, which identifies it in
+// reports and logs without exposing it: the first 16 hexadecimal digits of
+// its SHA-256 hash.
+func TokenFingerprint(token Secret) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token.Reveal()))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,398 @@
+package apple
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BulkToken is a token to revoke in bulk.
+type BulkToken struct {
+	ID       string        `json:"id,omitempty"`      // Your identifier of the token, copied to its result.
+	Subject  string        `json:"subject,omitempty"` // The user identifier (sub) of the owner, copied to its result.
+	Token    Secret        `json:"token"`             // The token.
+	TypeHint TokenTypeHint `json:"type_hint"`         // The type of the token, empty if unknown.
+}
+
+// TokenSource yields the tokens to revoke in bulk. The tokens must come in
+// the same order every time the source is read from the beginning, since a
+// resumed run skips the tokens already done by their position.
+type TokenSource interface {
+	// Next returns the next token, or io.EOF when there is no more token.
+	Next(ctx context.Context) (BulkToken, error)
+}
+
+// TokenSourceFunc is an adapter to use an ordinary function as a
+// TokenSource.
+type TokenSourceFunc func(ctx context.Context) (BulkToken, error)
+
+// Next calls f(ctx).
+func (f TokenSourceFunc) Next(ctx context.Context) (BulkToken, error) {
+	return f(ctx)
+}
+
+// NewSliceTokenSource returns a TokenSource yielding the tokens in order.
+func NewSliceTokenSource(tokens []BulkToken) TokenSource {
+	i := 0
+	return TokenSourceFunc(func(context.Context) (BulkToken, error) {
+		if i >= len(tokens) {
+			return BulkToken{}, io.EOF
+		}
+		i++
+		return tokens[i-1], nil
+	})
+}
+
+// NewJSONLTokenSource returns a TokenSource reading one BulkToken per line
+// from r, such as:
+//
+//	{"id":"42","subject":"001234.abcd","token":"r1a2b3...","type_hint":"refresh_token"}
+//
+// Blank lines are skipped.
+func NewJSONLTokenSource(r io.Reader) TokenSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	return TokenSourceFunc(func(context.Context) (BulkToken, error) {
+		for scanner.Scan() {
+			line++
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var token BulkToken
+			if err := json.Unmarshal(scanner.Bytes(), &token); err != nil {
+				return BulkToken{}, fmt.Errorf("invalid token at line %d: %w", line, err)
+			}
+			return token, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return BulkToken{}, err
+		}
+		return BulkToken{}, io.EOF
+	})
+}
+
+// BulkRevocationResult is the result of the revocation of a BulkToken.
+type BulkRevocationResult struct {
+	Seq     int64  `json:"seq"`               // The position of the token in the source, starting from 0.
+	ID      string `json:"id,omitempty"`      // The identifier of the token.
+	Subject string `json:"subject,omitempty"` // The user identifier of the owner.
+	TokenRevocation
+	Error string `json:"error,omitempty"` // The error of the revocation, if it failed.
+}
+
+// RevocationSink receives the result of every token revoked in bulk.
+type RevocationSink interface {
+	Write(result BulkRevocationResult) error
+}
+
+// RevocationSinkFunc is an adapter to use an ordinary function as a
+// RevocationSink.
+type RevocationSinkFunc func(result BulkRevocationResult) error
+
+// Write calls f(result).
+func (f RevocationSinkFunc) Write(result BulkRevocationResult) error {
+	return f(result)
+}
+
+// NewJSONLRevocationSink returns a RevocationSink writing every result as a
+// single JSON line to w.
+func NewJSONLRevocationSink(w io.Writer) RevocationSink {
+	encoder := json.NewEncoder(w)
+	return RevocationSinkFunc(func(result BulkRevocationResult) error {
+		return encoder.Encode(result)
+	})
+}
+
+// CheckpointStore persists the progress of a bulk job, which is the number
+// of leading tokens of the source that are done.
+type CheckpointStore interface {
+	// Load returns the saved progress, 0 if there is none.
+	Load() (int64, error)
+	// Save saves the progress.
+	Save(done int64) error
+}
+
+// FileCheckpoint is a CheckpointStore saving the progress in a file. The
+// file is replaced atomically on every save.
+type FileCheckpoint struct {
+	Path string
+}
+
+// NewFileCheckpoint creates a FileCheckpoint saving the progress at path.
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{Path: path}
+}
+
+func (f *FileCheckpoint) Load() (int64, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (f *FileCheckpoint) Save(done int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.WriteString(strconv.FormatInt(done, 10) + "\n"); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// BulkRevokeConfig configures BulkRevoke.
+type BulkRevokeConfig struct {
+	// ClientID is the identifier (App ID or Services ID) the tokens were
+	// issued to.
+	ClientID string
+
+	// ClientSecret is the client secret of ClientID.
+	ClientSecret Secret
+
+	// Workers is the number of tokens revoked at the same time, each of them
+	// sending one request at a time. Defaults to 4.
+	Workers int
+
+	// RateLimit limits the revocation requests of the run, a token tried
+	// with both hints taking two of them. Leave it zero when the client is
+	// rate limited already.
+	RateLimit RateLimit
+
+	// Retry is how a token failing temporarily is revoked again, before it
+	// is reported as failed and left for the next run. Leave MaxAttempts
+	// zero for DefaultRetryPolicy, or set it to 1 when the client retries
+	// already.
+	Retry RetryPolicy
+
+	// Sink receives the result of every token. Optional.
+	Sink RevocationSink
+
+	// Checkpoint persists the progress, so that an interrupted run resumes
+	// where it stopped. Optional.
+	Checkpoint CheckpointStore
+
+	// CheckpointEvery is the number of results between two saves of the
+	// progress. Defaults to 100. The progress is also saved when the run
+	// stops.
+	CheckpointEvery int
+}
+
+// BulkRevokeSummary is the outcome of BulkRevoke.
+type BulkRevokeSummary struct {
+	Resumed        int64         // The number of leading tokens skipped, as they were done by a previous run.
+	Revoked        int64         // The number of tokens revoked.
+	AlreadyInvalid int64         // The number of tokens already expired or revoked.
+	Failed         int64         // The number of tokens that could not be revoked.
+	Done           int64         // The progress saved to the checkpoint store, before the first temporary failure.
+	Duration       time.Duration // How long the run took.
+}
+
+// BulkRevoke revokes every token of source with a pool of workers, and
+// writes the result of every token to the sink.
+//
+// The progress is saved to the checkpoint store as the number of leading
+// tokens of the source that are done. A token that failed for good, such as
+// one rejected with invalid_request, is done too, its failure recorded in
+// the sink. A token that still failed temporarily after its retries is not:
+// the progress stops before it, so that running BulkRevoke again with the
+// same source and checkpoint store revokes it again, along with the tokens
+// after it, which Apple treats as a no-op.
+//
+// BulkRevoke stops at the first error of the source, the sink or the
+// checkpoint store, or when ctx is done, and returns the summary so far
+// along with the error.
+func BulkRevoke(ctx context.Context, client Client, config BulkRevokeConfig, source TokenSource) (*BulkRevokeSummary, error) {
+	start := time.Now()
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	every := config.CheckpointEvery
+	if every <= 0 {
+		every = 100
+	}
+	policy := jobRetryPolicy(config.Retry)
+	var limit *limiter
+	if !config.RateLimit.unlimited() {
+		limit = newLimiter(RateLimitPolicy{
+			Endpoints: map[Endpoint]RateLimit{EndpointRevoke: config.RateLimit},
+			Wait:      true,
+		})
+	}
+
+	summary := &BulkRevokeSummary{}
+	if config.Checkpoint != nil {
+		done, err := config.Checkpoint.Load()
+		if err != nil {
+			return summary, fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		summary.Resumed = done
+		summary.Done = done
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		seq   int64
+		token BulkToken
+	}
+	jobs := make(chan job)
+	results := make(chan BulkRevocationResult)
+
+	// read the source, skipping the tokens done
+	var sourceErr error
+	go func() {
+		defer close(jobs)
+		for seq := int64(0); ; seq++ {
+			token, err := source.Next(ctx)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				sourceErr = err
+				cancel()
+				return
+			}
+			if seq < summary.Resumed {
+				continue
+			}
+			select {
+			case jobs <- job{seq: seq, token: token}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				stored := StoredToken{Token: j.token.Token, TypeHint: j.token.TypeHint}
+				result := BulkRevocationResult{
+					Seq:             j.seq,
+					ID:              j.token.ID,
+					Subject:         j.token.Subject,
+					TokenRevocation: revokeStoredToken(ctx, client, config.ClientID, config.ClientSecret, stored, policy, limit),
+				}
+				if result.Err != nil {
+					result.Error = result.Err.Error()
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// collect the results, and move the progress forward over the
+	// contiguous tokens done, up to the first temporary failure
+	var runErr error
+	completed := make(map[int64]bool)
+	contiguous, retryFrom := summary.Done, int64(-1)
+	sinceSave := 0
+	save := func() error {
+		if config.Checkpoint == nil {
+			return nil
+		}
+		sinceSave = 0
+		if err := config.Checkpoint.Save(summary.Done); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		return nil
+	}
+	fail := func(err error) {
+		if runErr == nil {
+			runErr = err
+			cancel()
+		}
+	}
+
+	for result := range results {
+		if result.Outcome == RevocationFailed && ctx.Err() != nil {
+			continue // interrupted, not done
+		}
+		if runErr != nil {
+			continue
+		}
+		if config.Sink != nil {
+			if err := config.Sink.Write(result); err != nil {
+				fail(fmt.Errorf("failed to write result: %w", err))
+				continue
+			}
+		}
+
+		switch result.Outcome {
+		case RevocationRevoked:
+			summary.Revoked++
+		case RevocationAlreadyInvalid:
+			summary.AlreadyInvalid++
+		default:
+			summary.Failed++
+		}
+
+		if result.Outcome == RevocationFailed && isTemporary(result.Err) && (retryFrom < 0 || result.Seq < retryFrom) {
+			retryFrom = result.Seq
+		}
+		completed[result.Seq] = true
+		for completed[contiguous] {
+			delete(completed, contiguous)
+			contiguous++
+		}
+		summary.Done = contiguous
+		if retryFrom >= 0 && retryFrom < contiguous {
+			summary.Done = retryFrom
+		}
+
+		if sinceSave++; sinceSave >= every {
+			if err := save(); err != nil {
+				fail(err)
+			}
+		}
+	}
+
+	if err := save(); err != nil && runErr == nil {
+		runErr = err
+	}
+	summary.Duration = time.Since(start)
+
+	switch {
+	case runErr != nil:
+		return summary, runErr
+	case sourceErr != nil:
+		return summary, fmt.Errorf("failed to read token: %w", sourceErr)
+	default:
+		return summary, context.Cause(ctx)
+	}
+}
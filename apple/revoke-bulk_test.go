@@ -0,0 +1,100 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryCheckpoint is a CheckpointStore in memory
+type memoryCheckpoint struct {
+	done int64
+}
+
+func (m *memoryCheckpoint) Load() (int64, error) { return m.done, nil }
+
+func (m *memoryCheckpoint) Save(done int64) error {
+	m.done = done
+	return nil
+}
+
+func TestBulkRevokeKeepsTemporaryFailuresForTheNextRun(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	requests := make(map[string]int)
+	c := newStubClient(t, func(req *Request) *Response {
+		token := req.Form["token"]
+		requests[token]++
+		if token == "t1" && down.Load() {
+			return &Response{StatusCode: http.StatusServiceUnavailable}
+		}
+		return &Response{StatusCode: http.StatusOK}
+	})
+
+	tokens := []BulkToken{
+		{Token: "t0", TypeHint: TokenTypeRefreshToken},
+		{Token: "t1", TypeHint: TokenTypeRefreshToken},
+		{Token: "t2", TypeHint: TokenTypeRefreshToken},
+	}
+	checkpoint := &memoryCheckpoint{}
+	config := BulkRevokeConfig{
+		ClientID:     "com.example.app",
+		ClientSecret: "secret",
+		Workers:      1,
+		Retry:        RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		Checkpoint:   checkpoint,
+	}
+
+	summary, err := BulkRevoke(context.Background(), c, config, NewSliceTokenSource(tokens))
+	if err != nil {
+		t.Fatalf("BulkRevoke() error = %v", err)
+	}
+	if summary.Revoked != 2 || summary.Failed != 1 || summary.Done != 1 || checkpoint.done != 1 {
+		t.Errorf("summary = %+v, checkpoint = %d, want 2 revoked, 1 failed and 1 done", summary, checkpoint.done)
+	}
+	if requests["t1"] != 2 {
+		t.Errorf("requests of t1 = %d, want 2 attempts", requests["t1"])
+	}
+
+	// the next run revokes the failed token again
+	down.Store(false)
+	summary, err = BulkRevoke(context.Background(), c, config, NewSliceTokenSource(tokens))
+	if err != nil {
+		t.Fatalf("BulkRevoke() error = %v", err)
+	}
+	if summary.Resumed != 1 || summary.Revoked != 2 || summary.Done != 3 || checkpoint.done != 3 {
+		t.Errorf("summary = %+v, checkpoint = %d, want 1 resumed, 2 revoked and 3 done", summary, checkpoint.done)
+	}
+}
+
+func TestBulkRevokeRateLimitsEveryRequest(t *testing.T) {
+	var requests atomic.Int32
+	c := newStubClient(t, func(req *Request) *Response {
+		requests.Add(1)
+		if req.Form["token_type_hint"] == string(TokenTypeRefreshToken) {
+			return &Response{StatusCode: http.StatusBadRequest, Body: []byte(`{"error":"unsupported_token_type"}`)}
+		}
+		return &Response{StatusCode: http.StatusOK}
+	})
+
+	// every token is tried as a refresh token, then as an access token
+	tokens := []BulkToken{{Token: "t0"}, {Token: "t1"}}
+	start := time.Now()
+	summary, err := BulkRevoke(context.Background(), c, BulkRevokeConfig{
+		ClientID:     "com.example.app",
+		ClientSecret: "secret",
+		Workers:      2,
+		RateLimit:    RateLimit{Rate: 10, Burst: 1},
+	}, NewSliceTokenSource(tokens))
+	if err != nil {
+		t.Fatalf("BulkRevoke() error = %v", err)
+	}
+	if summary.Revoked != 2 || requests.Load() != 4 {
+		t.Fatalf("summary = %+v after %d requests, want 2 revoked after 4 requests", summary, requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("4 requests took %s, want at least 300ms at 10 requests per second", elapsed)
+	}
+}
@@ -0,0 +1,119 @@
+package apple
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ClientSecretInfo is the decoded content of a client_secret.
+type ClientSecretInfo struct {
+	KeyID     string    // The key ID of the signing key, the kid header.
+	TeamID    string    // The Team ID, the iss claim.
+	ClientID  string    // The client ID, the sub claim.
+	Audience  []string  // The aud claim, Apple expects "https://appleid.apple.com".
+	IssuedAt  time.Time // The iat claim.
+	ExpiresAt time.Time // The exp claim.
+}
+
+// ExpiresWithin reports whether the client_secret expires within d from now,
+// or is already expired.
+func (i *ClientSecretInfo) ExpiresWithin(d time.Duration) bool {
+	return time.Until(i.ExpiresAt) <= d
+}
+
+// ParseClientSecret decodes a client_secret, such as one generated by
+// GenerateClientSecret and kept in a config system.
+//
+// If authKey is not nil, the signature is checked against the public key of
+// its signing key, and the kid, iss and sub of the client_secret must match
+// the key. An expired client_secret is decoded anyway, check ExpiresAt.
+func ParseClientSecret(clientSecret Secret, authKey *AuthKey) (*ClientSecretInfo, error) {
+	claims := &jwt.RegisteredClaims{}
+
+	var token *jwt.Token
+	var err error
+	if authKey == nil {
+		token, _, err = jwt.NewParser().ParseUnverified(clientSecret.Reveal(), claims)
+	} else {
+		var signer crypto.Signer
+		if signer = authKey.Signer; signer == nil {
+			if signer, err = parseSigningKey(authKey.SigningKey); err != nil {
+				return nil, err
+			}
+		}
+		parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}), jwt.WithoutClaimsValidation())
+		token, err = parser.ParseWithClaims(clientSecret.Reveal(), claims, func(*jwt.Token) (any, error) {
+			return signer.Public(), nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid client secret: %w", err)
+	}
+
+	info := &ClientSecretInfo{
+		TeamID:   claims.Issuer,
+		ClientID: claims.Subject,
+		Audience: claims.Audience,
+	}
+	info.KeyID, _ = token.Header["kid"].(string)
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("invalid client secret: no exp claim")
+	}
+	info.ExpiresAt = claims.ExpiresAt.Time
+
+	if authKey != nil {
+		switch {
+		case info.KeyID != authKey.KeyID:
+			return nil, fmt.Errorf("client secret is signed by key %q, not %q", info.KeyID, authKey.KeyID)
+		case info.TeamID != authKey.TeamID:
+			return nil, fmt.Errorf("client secret is issued by team %q, not %q", info.TeamID, authKey.TeamID)
+		case info.ClientID != authKey.ClientID:
+			return nil, fmt.Errorf("client secret is issued for client %q, not %q", info.ClientID, authKey.ClientID)
+		}
+	}
+	return info, nil
+}
+
+// NewClientSecretExpiryMiddleware returns a Middleware calling warn when the
+// client_secret of a request expires within the given duration, such as 30
+// days. warn is called at most once a day per client_secret, and the request
+// is sent anyway.
+func NewClientSecretExpiryMiddleware(within time.Duration, warn func(info *ClientSecretInfo)) Middleware {
+	var mu sync.Mutex
+	warned := make(map[string]time.Time) // by fingerprint of the client_secret
+
+	return func(next Doer) Doer {
+		return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+			clientSecret := Secret(req.Form["client_secret"])
+			if clientSecret == "" {
+				return next.Do(ctx, req)
+			}
+
+			info, err := ParseClientSecret(clientSecret, nil)
+			if err == nil && info.ExpiresWithin(within) {
+				fingerprint := TokenFingerprint(clientSecret)
+
+				mu.Lock()
+				due := time.Since(warned[fingerprint]) >= 24*time.Hour
+				if due {
+					warned[fingerprint] = time.Now()
+				}
+				mu.Unlock()
+
+				if due {
+					warn(info)
+				}
+			}
+			return next.Do(ctx, req)
+		})
+	}
+}
@@ -1,9 +1,8 @@
 package apple
 
 import (
-	"crypto/x509"
-	"encoding/pem"
-	"errors"
+	"crypto"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -41,37 +40,111 @@ type AuthKey struct {
 	//
 	// This sensitive value should be stored securely and never commited to
 	// Version Control System.
-	SigningKey string `json:"-"`
+	SigningKey Secret `json:"-"`
+
+	// Signer signs the client secrets in place of SigningKey, when the
+	// private key is kept out of the application memory, such as in an HSM.
+	// It must hold an ECDSA P-256 key. See NewFileSigner.
+	Signer crypto.Signer `json:"-"`
+}
+
+// MaxClientSecretLifetime is the longest lifetime of a client_secret Apple
+// accepts, which is 15777000 seconds (6 months).
+const MaxClientSecretLifetime = 15777000 * time.Second
+
+// defaultClientSecretLifetime is the lifetime of the client secrets issued
+// by GenerateClientSecret
+const defaultClientSecretLifetime = 180*24*time.Hour - time.Second
+
+// ClientSecretOptions configures GenerateClientSecretWithOptions.
+type ClientSecretOptions struct {
+	// Lifetime is how long the client_secret is valid, up to
+	// MaxClientSecretLifetime. Defaults to 180 days.
+	Lifetime time.Duration
+
+	// IssuedAt is the issue time of the client_secret. Defaults to now.
+	IssuedAt time.Time
+
+	// Audience is the audience of the client_secret. Defaults to
+	// "https://appleid.apple.com".
+	Audience string
+
+	// Claims are custom claims added to the client_secret. They must not
+	// include the claims set by GenerateClientSecretWithOptions: iss, sub,
+	// aud, exp and iat.
+	Claims map[string]any
 }
 
+// clientSecretClaims are the claims of a client_secret set from the AuthKey
+// and the options, which custom claims cannot override
+var clientSecretClaims = []string{"iss", "sub", "aud", "exp", "iat"}
+
 // GenerateClientSecret generates the client_secret used to make request to
 // the Sign in with Apple REST API. A client_secret expires after 6 months.
 //
 // Ref: https://developer.apple.com/documentation/AccountOrganizationalDataSharing/creating-a-client-secret
-func GenerateClientSecret(authKey AuthKey) (string, error) {
-	block, _ := pem.Decode([]byte(authKey.SigningKey))
-	if block == nil {
-		return "", errors.New("failed to decode signing private key")
+func GenerateClientSecret(authKey AuthKey) (Secret, error) {
+	clientSecret, _, err := GenerateClientSecretWithOptions(authKey, ClientSecretOptions{})
+	return clientSecret, err
+}
+
+// GenerateClientSecretWithOptions generates the client_secret used to make
+// request to the Sign in with Apple REST API, and returns it along with its
+// expiry time, so that a new one can be generated before it expires.
+//
+// Ref: https://developer.apple.com/documentation/AccountOrganizationalDataSharing/creating-a-client-secret
+func GenerateClientSecretWithOptions(authKey AuthKey, opts ClientSecretOptions) (clientSecret Secret, expiresAt time.Time, err error) {
+	lifetime := opts.Lifetime
+	switch {
+	case lifetime == 0:
+		lifetime = defaultClientSecretLifetime
+	case lifetime < 0:
+		return "", time.Time{}, fmt.Errorf("client secret lifetime %s must be positive", lifetime)
+	case lifetime > MaxClientSecretLifetime:
+		return "", time.Time{}, fmt.Errorf("client secret lifetime %s exceeds Apple's maximum of %s", lifetime, MaxClientSecretLifetime)
+	}
+	issuedAt := opts.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = time.Now()
+	}
+	audience := opts.Audience
+	if audience == "" {
+		audience = baseURL
 	}
 
-	privKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return "", err
+	signer := authKey.Signer
+	if signer == nil {
+		if signer, err = parseSigningKey(authKey.SigningKey); err != nil {
+			return "", time.Time{}, err
+		}
 	}
 
-	now := time.Now()
+	// JWT times are in seconds, the expiry is truncated the same way
+	issuedAt = issuedAt.Truncate(time.Second)
+	expiresAt = issuedAt.Add(lifetime)
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:    authKey.TeamID,
-		Subject:   authKey.ClientID,
-		Audience:  jwt.ClaimStrings{"https://appleid.apple.com"},
-		ExpiresAt: &jwt.NumericDate{Time: now.Add(180*24*time.Hour - time.Second)}, // 6 months
-		IssuedAt:  &jwt.NumericDate{Time: now},
+	claims := jwt.MapClaims{}
+	for name, value := range opts.Claims {
+		claims[name] = value
+	}
+	for _, name := range clientSecretClaims {
+		if _, ok := claims[name]; ok {
+			return "", time.Time{}, fmt.Errorf("custom claim %q is set from the auth key and the options", name)
+		}
 	}
+	claims["iss"] = authKey.TeamID
+	claims["sub"] = authKey.ClientID
+	claims["aud"] = jwt.ClaimStrings{audience}
+	claims["exp"] = jwt.NewNumericDate(expiresAt)
+	claims["iat"] = jwt.NewNumericDate(issuedAt)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
 	token.Header["alg"] = "ES256"
 	token.Header["kid"] = authKey.KeyID
 
-	return token.SignedString(privKey)
+	signed, err := signES256(token, signer)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return Secret(signed), expiresAt, nil
 }
@@ -0,0 +1,73 @@
+package apple
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testAuthKey returns an AuthKey signing with a new in-memory key
+func testAuthKey(t *testing.T) AuthKey {
+	t.Helper()
+	signer, err := NewTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AuthKey{KeyID: "AB12CD34EF", ClientID: "com.example.app", TeamID: "GH56IJ78KL", Signer: signer}
+}
+
+func TestGenerateClientSecretWithOptionsClaims(t *testing.T) {
+	authKey := testAuthKey(t)
+	issuedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	clientSecret, expiresAt, err := GenerateClientSecretWithOptions(authKey, ClientSecretOptions{
+		Lifetime: time.Hour,
+		IssuedAt: issuedAt,
+		Claims:   map[string]any{"nonce": "n-0S6_WzA2Mj", "scope": []string{"name", "email"}},
+	})
+	if err != nil {
+		t.Fatalf("GenerateClientSecretWithOptions() error = %v", err)
+	}
+	if !expiresAt.Equal(issuedAt.Add(time.Hour)) {
+		t.Errorf("expiresAt = %v, want %v", expiresAt, issuedAt.Add(time.Hour))
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	if _, err = parser.ParseWithClaims(clientSecret.Reveal(), claims, func(*jwt.Token) (any, error) {
+		return authKey.Signer.Public(), nil
+	}); err != nil {
+		t.Fatalf("ParseWithClaims() error = %v", err)
+	}
+
+	want := map[string]any{
+		"iss":   "GH56IJ78KL",
+		"sub":   "com.example.app",
+		"nonce": "n-0S6_WzA2Mj",
+		"iat":   float64(issuedAt.Unix()),
+		"exp":   float64(issuedAt.Add(time.Hour).Unix()),
+	}
+	for name, value := range want {
+		if claims[name] != value {
+			t.Errorf("claim %q = %v, want %v", name, claims[name], value)
+		}
+	}
+	if !claims.VerifyAudience(baseURL, true) {
+		t.Errorf("claim aud = %v, want %q", claims["aud"], baseURL)
+	}
+	if scope, _ := claims["scope"].([]any); len(scope) != 2 {
+		t.Errorf("claim scope = %v, want [name email]", claims["scope"])
+	}
+}
+
+func TestGenerateClientSecretWithOptionsReservedClaims(t *testing.T) {
+	authKey := testAuthKey(t)
+	for _, name := range clientSecretClaims {
+		_, _, err := GenerateClientSecretWithOptions(authKey, ClientSecretOptions{Claims: map[string]any{name: "x"}})
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("custom claim %q: error = %v, want an error", name, err)
+		}
+	}
+}
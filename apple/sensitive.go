@@ -0,0 +1,57 @@
+package apple
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// Secret is a string holding a credential, such as a signing key, a client
+// secret or a token.
+//
+// A Secret is redacted whenever it is printed, formatted, logged or
+// marshaled to JSON, so that it does not leak through a panic or a debug
+// log. Call Reveal to get the actual value. An empty Secret stays empty.
+type Secret string
+
+// Reveal returns the actual value of the secret.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer, the secret is redacted.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString implements fmt.GoStringer, the secret is redacted.
+func (s Secret) GoString() string {
+	return "apple.Secret(" + strconv.Quote(s.String()) + ")"
+}
+
+// Format implements fmt.Formatter, the secret is redacted whatever the verb
+// is.
+func (s Secret) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		_, _ = fmt.Fprint(f, s.GoString())
+	case verb == 'q':
+		_, _ = fmt.Fprint(f, strconv.Quote(s.String()))
+	default:
+		_, _ = fmt.Fprint(f, s.String())
+	}
+}
+
+// MarshalJSON implements json.Marshaler, the secret is redacted. Marshal
+// s.Reveal() instead to store the actual value.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+// LogValue implements slog.LogValuer, the secret is redacted.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
@@ -0,0 +1,130 @@
+package apple
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// FileSigner is a crypto.Signer reading the private key from a .p8 file
+// every time it signs, so that the key is not held in memory in between.
+type FileSigner struct {
+	path   string
+	public crypto.PublicKey
+}
+
+// NewFileSigner creates a FileSigner of the .p8 file downloaded from the
+// Apple Developer Portal. The file is read once to check the key.
+func NewFileSigner(path string) (*FileSigner, error) {
+	key, err := readSigningKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSigner{path: path, public: key.Public()}, nil
+}
+
+// Public returns the public key of the signing key.
+func (s *FileSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs digest with the private key read from the file.
+func (s *FileSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := readSigningKeyFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !key.PublicKey.Equal(s.public) {
+		return nil, fmt.Errorf("signing key in %q has changed", s.path)
+	}
+	return key.Sign(rand, digest, opts)
+}
+
+func readSigningKeyFile(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseSigningKey(Secret(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key in %q: %w", path, err)
+	}
+	return key, nil
+}
+
+// NewTestSigner creates a crypto.Signer holding a new ECDSA P-256 key in
+// memory, to generate client secrets in tests without an actual key from
+// Apple. Use its Public method to verify the client secrets.
+func NewTestSigner() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// parseSigningKey parses the PEM-encoded PKCS#8 ECDSA P-256 private key of
+// an AuthKey
+func parseSigningKey(signingKey Secret) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(signingKey.Reveal()))
+	if block == nil {
+		return nil, errors.New("failed to decode signing private key")
+	}
+	if block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing private key is a %q PEM block, expected a PKCS#8 \"PRIVATE KEY\" as in the .p8 file", block.Type)
+	}
+
+	privKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := privKey.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, errors.New("signing private key is not an ECDSA P-256 key")
+	}
+	return key, nil
+}
+
+// signES256 signs token with signer, converting the ASN.1 DER signature of
+// crypto.Signer to the fixed-size r||s signature of ES256
+func signES256(token *jwt.Token, signer crypto.Signer) (string, error) {
+	public, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || public.Curve != elliptic.P256() {
+		return "", errors.New("signer does not hold an ECDSA P-256 key")
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(signingString))
+
+	der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client secret: %w", err)
+	}
+
+	var sig struct{ R, S *big.Int }
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil || len(rest) > 0 {
+		return "", errors.New("signer returned an invalid ASN.1 ECDSA signature")
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > 256 || sig.S.BitLen() > 256 {
+		return "", errors.New("signer returned an out of range ECDSA signature")
+	}
+
+	raw := make([]byte, 64)
+	sig.R.FillBytes(raw[:32])
+	sig.S.FillBytes(raw[32:])
+
+	return strings.Join([]string{signingString, jwt.EncodeSegment(raw)}, "."), nil
+}
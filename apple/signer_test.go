@@ -0,0 +1,177 @@
+package apple
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// shortSigner is a crypto.Signer signing until the signature matches want,
+// so that signatures with a short R or S can be tested
+type shortSigner struct {
+	key  *ecdsa.PrivateKey
+	want func(r, s *big.Int) bool
+}
+
+func (s *shortSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *shortSigner) Sign(rand io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	for {
+		r, ss, err := ecdsa.Sign(rand, s.key, digest)
+		if err != nil {
+			return nil, err
+		}
+		if s.want(r, ss) {
+			return asn1.Marshal(struct{ R, S *big.Int }{r, ss})
+		}
+	}
+}
+
+// isShort reports whether n needs left-padding to 32 bytes
+func isShort(n *big.Int) bool {
+	return n.BitLen() <= 248
+}
+
+func TestSignES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want func(r, s *big.Int) bool
+		pad  func(sig []byte) bool
+	}{
+		{"any", func(r, s *big.Int) bool { return true }, nil},
+		{"short R", func(r, s *big.Int) bool { return isShort(r) && !isShort(s) }, func(sig []byte) bool { return sig[0] == 0 }},
+		{"short S", func(r, s *big.Int) bool { return !isShort(r) && isShort(s) }, func(sig []byte) bool { return sig[32] == 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authKey := AuthKey{
+				KeyID:    "AB12CD34EF",
+				ClientID: "com.example.app",
+				TeamID:   "GH56IJ78KL",
+				Signer:   &shortSigner{key: key, want: tt.want},
+			}
+			clientSecret, expiresAt, err := GenerateClientSecretWithOptions(authKey, ClientSecretOptions{Lifetime: time.Hour})
+			if err != nil {
+				t.Fatalf("GenerateClientSecretWithOptions() error = %v", err)
+			}
+
+			parts := strings.Split(clientSecret.Reveal(), ".")
+			if len(parts) != 3 {
+				t.Fatalf("client secret has %d parts, want 3", len(parts))
+			}
+			sig, err := jwt.DecodeSegment(parts[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sig) != 64 {
+				t.Fatalf("signature is %d bytes, want 64", len(sig))
+			}
+			if tt.pad != nil && !tt.pad(sig) {
+				t.Errorf("signature %x is not left-padded", sig)
+			}
+
+			signingString := parts[0] + "." + parts[1]
+			if err = jwt.SigningMethodES256.Verify(signingString, parts[2], &key.PublicKey); err != nil {
+				t.Errorf("SigningMethodES256.Verify() error = %v", err)
+			}
+
+			info, err := ParseClientSecret(clientSecret, &authKey)
+			if err != nil {
+				t.Fatalf("ParseClientSecret() error = %v", err)
+			}
+			if info.KeyID != authKey.KeyID || info.TeamID != authKey.TeamID || info.ClientID != authKey.ClientID {
+				t.Errorf("ParseClientSecret() = %+v, want the key, team and client of the auth key", info)
+			}
+			if !info.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("ExpiresAt = %v, want %v", info.ExpiresAt, expiresAt)
+			}
+		})
+	}
+}
+
+func TestSignES256RejectsOtherKeys(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authKey := AuthKey{KeyID: "AB12CD34EF", ClientID: "com.example.app", TeamID: "GH56IJ78KL", Signer: p384}
+	if _, err = GenerateClientSecret(authKey); err == nil {
+		t.Error("GenerateClientSecret() with a P-384 key error = nil, want an error")
+	}
+}
+
+func TestParseClientSecretWrongKey(t *testing.T) {
+	authKey, other := testAuthKey(t), testAuthKey(t)
+	clientSecret, err := GenerateClientSecret(authKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseClientSecret(clientSecret, &other); err == nil {
+		t.Error("ParseClientSecret() with another key error = nil, want an error")
+	}
+	if _, err = ParseClientSecret(clientSecret, nil); err != nil {
+		t.Errorf("ParseClientSecret() without key error = %v", err)
+	}
+}
+
+func TestFileSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "AuthKey_AB12CD34EF.p8")
+	if err = os.WriteFile(path, signingKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewFileSigner(path)
+	if err != nil {
+		t.Fatalf("NewFileSigner() error = %v", err)
+	}
+	fromFile := AuthKey{KeyID: "AB12CD34EF", ClientID: "com.example.app", TeamID: "GH56IJ78KL", Signer: signer}
+	clientSecret, err := GenerateClientSecret(fromFile)
+	if err != nil {
+		t.Fatalf("GenerateClientSecret() error = %v", err)
+	}
+
+	// the same key as PEM verifies it
+	fromPEM := AuthKey{KeyID: "AB12CD34EF", ClientID: "com.example.app", TeamID: "GH56IJ78KL", SigningKey: Secret(signingKey)}
+	if _, err = ParseClientSecret(clientSecret, &fromPEM); err != nil {
+		t.Errorf("ParseClientSecret() error = %v", err)
+	}
+
+	// a replaced key file is refused
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ = x509.MarshalPKCS8PrivateKey(other)
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GenerateClientSecret(fromFile); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("GenerateClientSecret() with a replaced key file error = %v, want an error", err)
+	}
+}
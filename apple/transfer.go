@@ -0,0 +1,53 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TransferSubResolver exchanges the transfer identifier of a user for the
+// user identifier in the recipient team, such as
+// RecipientTeam.ExchangeIdentifier.
+type TransferSubResolver func(ctx context.Context, transferSub string) (*ExchangeIdentifierResponse, error)
+
+// ResolveTransferSub detects the transfer_sub claim of a verified id_token,
+// which is present when the user signs in after their team transferred the
+// app, and before their identifier is exchanged. It returns nil if there is
+// no transfer_sub claim.
+//
+// The transfer identifier is resolved with resolver, if not nil, so that the
+// user can be linked to their account during the transfer:
+//
+//	user, err := apple.ResolveTransferSub(ctx, token, recipient.ExchangeIdentifier)
+//
+// Ref: https://developer.apple.com/documentation/sign_in_with_apple/bringing-new-apps-and-users-into-your-team
+func ResolveTransferSub(ctx context.Context, token *jwt.Token, resolver TransferSubResolver) (*TransferredUser, error) {
+	sub, err := GetUniqueID(token)
+	if err != nil {
+		return nil, err
+	}
+	transferSub, ok := GetTransferSub(token)
+	if !ok {
+		return nil, nil
+	}
+
+	user := &TransferredUser{TransferSub: transferSub, Sub: sub}
+	if resolver == nil {
+		return user, nil
+	}
+
+	rsp, err := resolver(ctx, transferSub)
+	if err != nil {
+		return user, fmt.Errorf("failed to resolve transfer_sub: %w", err)
+	}
+	if rsp == nil || rsp.Sub == "" {
+		return user, errors.New("failed to resolve transfer_sub: no sub")
+	}
+	user.NewSub = rsp.Sub
+	user.Email = rsp.Email
+	user.IsPrivateEmail = rsp.IsPrivateEmail
+	return user, nil
+}